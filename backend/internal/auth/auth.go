@@ -0,0 +1,20 @@
+// Package auth recognises admin requests and defines the roles users hold
+// on documents.
+package auth
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// IsAdmin reports whether the request sends the admin token as
+// "Authorization: Bearer <token>". With no token configured nobody is admin.
+func IsAdmin(c *gin.Context, token string) bool {
+	if token == "" {
+		return false
+	}
+	given, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,33 @@
+package auth
+
+// Role is what a user may do with a document. Each role includes the
+// permissions of the roles below it.
+type Role string
+
+const (
+	RoleNone      Role = "none"
+	RoleViewer    Role = "viewer"
+	RoleCommenter Role = "commenter"
+	RoleEditor    Role = "editor"
+	RoleOwner     Role = "owner"
+)
+
+var roleRank = map[Role]int{
+	RoleNone:      0,
+	RoleViewer:    1,
+	RoleCommenter: 2,
+	RoleEditor:    3,
+	RoleOwner:     4,
+}
+
+// ValidRole reports whether r is a known role, including RoleNone.
+func ValidRole(r Role) bool {
+	_, ok := roleRank[r]
+	return ok
+}
+
+// AtLeast reports whether r grants everything min does. Unknown roles grant nothing.
+func (r Role) AtLeast(min Role) bool {
+	rank, ok := roleRank[r]
+	return ok && rank >= roleRank[min]
+}
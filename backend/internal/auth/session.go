@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidSession = errors.New("invalid session token")
+	ErrExpiredSession = errors.New("session token has expired")
+)
+
+// SessionClaims are the fields signed into a session token, which is what
+// identifies a user to the server.
+type SessionClaims struct {
+	UserID    uuid.UUID `json:"u"`
+	ExpiresAt int64     `json:"e"`
+}
+
+func (s *Signer) SignSession(claims SessionClaims) string {
+	return s.sign(claims)
+}
+
+// VerifySession checks a session token's signature and expiry and returns
+// its claims.
+func (s *Signer) VerifySession(token string, now time.Time) (SessionClaims, error) {
+	var claims SessionClaims
+	if !s.open(token, &claims) || claims.UserID == uuid.Nil {
+		return claims, ErrInvalidSession
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return claims, ErrExpiredSession
+	}
+	return claims, nil
+}
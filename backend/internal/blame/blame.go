@@ -0,0 +1,189 @@
+// Package blame attributes every byte of a document to the change that wrote
+// it, by replaying committed changes.
+package blame
+
+import (
+	"sync"
+	"time"
+
+	"storychain-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// maxCached bounds how many documents a Cache keeps.
+const maxCached = 512
+
+// Doc is the authorship of a document at a revision.
+type Doc struct {
+	Revision int64              `json:"revision"`
+	Spans    []models.BlameSpan `json:"spans"`
+}
+
+// New returns the authorship of text that no recorded change explains, such
+// as a document's initial content.
+func New(length int, revision int64) *Doc {
+	d := &Doc{Revision: revision}
+	if length > 0 {
+		d.Spans = []models.BlameSpan{{Start: 0, End: length}}
+	}
+	return d
+}
+
+// Apply attributes a normalized change that produced revision.
+func (d *Doc) Apply(change models.TextChange, changeID uuid.UUID, at time.Time, revision int64) {
+	removed := 0
+	if change.ChangeType == "delete" || change.ChangeType == "replace" {
+		removed = change.Length
+	}
+	inserted := 0
+	if change.ChangeType == "insert" || change.ChangeType == "replace" {
+		inserted = len(change.Content)
+	}
+	start, end := change.Position, change.Position+removed
+
+	spans := make([]models.BlameSpan, 0, len(d.Spans)+2)
+	var added bool
+	add := func() {
+		if !added && inserted > 0 {
+			id, ts := changeID, at
+			spans = append(spans, models.BlameSpan{
+				Start: start, End: start + inserted, ChangeID: &id,
+				UserID: change.UserID, UserName: change.UserName, Timestamp: &ts,
+			})
+		}
+		added = true
+	}
+	for _, span := range d.Spans {
+		switch {
+		case span.End <= start:
+			spans = append(spans, span)
+			continue
+		case span.Start >= end && span.Start >= start:
+			add()
+			span.Start += inserted - removed
+			span.End += inserted - removed
+			spans = append(spans, span)
+			continue
+		}
+		// The span overlaps the edit: keep what lies before and after it
+		if span.Start < start {
+			before := span
+			before.End = start
+			spans = append(spans, before)
+		}
+		add()
+		if span.End > end {
+			after := span
+			after.Start = start + inserted
+			after.End = span.End - removed + inserted
+			spans = append(spans, after)
+		}
+	}
+	add()
+	d.Spans = merge(spans)
+	d.Revision = revision
+}
+
+// Fit trims or extends the spans to cover exactly length bytes, for history
+// that does not replay to the stored content.
+func (d *Doc) Fit(length int) {
+	spans := d.Spans[:0]
+	for _, span := range d.Spans {
+		if span.Start >= length {
+			break
+		}
+		if span.End > length {
+			span.End = length
+		}
+		spans = append(spans, span)
+	}
+	end := 0
+	if len(spans) > 0 {
+		end = spans[len(spans)-1].End
+	}
+	if end < length {
+		spans = append(spans, models.BlameSpan{Start: end, End: length})
+	}
+	d.Spans = merge(spans)
+}
+
+// merge joins adjacent spans written by the same change.
+func merge(spans []models.BlameSpan) []models.BlameSpan {
+	out := spans[:0]
+	for _, span := range spans {
+		if span.End <= span.Start {
+			continue
+		}
+		if n := len(out); n > 0 && sameChange(out[n-1], span) && out[n-1].End == span.Start {
+			out[n-1].End = span.End
+			continue
+		}
+		out = append(out, span)
+	}
+	return out
+}
+
+func sameChange(a, b models.BlameSpan) bool {
+	if a.ChangeID == nil || b.ChangeID == nil {
+		return a.ChangeID == nil && b.ChangeID == nil
+	}
+	return *a.ChangeID == *b.ChangeID
+}
+
+// Cache keeps the latest authorship of recently viewed documents and
+// advances it as changes commit.
+type Cache struct {
+	mu   sync.Mutex
+	docs map[uuid.UUID]*Doc
+}
+
+func NewCache() *Cache {
+	return &Cache{docs: make(map[uuid.UUID]*Doc)}
+}
+
+// Get returns a copy of a document's cached authorship, if any.
+func (c *Cache) Get(documentID uuid.UUID) (Doc, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.docs[documentID]
+	if !ok {
+		return Doc{}, false
+	}
+	return Doc{Revision: d.Revision, Spans: append([]models.BlameSpan(nil), d.Spans...)}, true
+}
+
+// Put stores a document's authorship unless a newer one is already cached.
+// A replay that raced commits may be stored behind them; readers compare
+// Revision with the document's and replay again when they differ.
+func (c *Cache) Put(documentID uuid.UUID, d *Doc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.docs[documentID]; ok && cached.Revision >= d.Revision {
+		return
+	}
+	if len(c.docs) >= maxCached {
+		for id := range c.docs {
+			delete(c.docs, id)
+			break
+		}
+	}
+	c.docs[documentID] = d
+}
+
+// Apply advances a cached document by a committed change. If the change is
+// not the next revision, some commit was missed and the entry is dropped so
+// the next read replays the history.
+func (c *Cache) Apply(documentID uuid.UUID, change models.TextChange, changeID uuid.UUID, at time.Time, revision int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d, ok := c.docs[documentID]
+	if !ok {
+		return
+	}
+	if d.Revision != revision-1 {
+		delete(c.docs, documentID)
+		return
+	}
+	d.Apply(change, changeID, at, revision)
+}
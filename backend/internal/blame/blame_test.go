@@ -0,0 +1,175 @@
+package blame
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"storychain-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	first  = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+	second = uuid.MustParse("00000000-0000-0000-0000-000000000002")
+	at     = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+)
+
+// describe writes spans as "start-end:owner", where the owner is 1 or 2 for
+// the test changes and ? for text no change explains.
+func describe(spans []models.BlameSpan) []string {
+	out := []string{}
+	for _, span := range spans {
+		owner := "?"
+		switch {
+		case span.ChangeID == nil:
+		case *span.ChangeID == first:
+			owner = "1"
+		case *span.ChangeID == second:
+			owner = "2"
+		}
+		out = append(out, fmt.Sprintf("%d-%d:%s", span.Start, span.End, owner))
+	}
+	return out
+}
+
+func ins(pos int, text string) models.TextChange {
+	return models.TextChange{ChangeType: "insert", Position: pos, Content: text}
+}
+
+func del(pos, length int) models.TextChange {
+	return models.TextChange{ChangeType: "delete", Position: pos, Length: length}
+}
+
+func rep(pos, length int, text string) models.TextChange {
+	return models.TextChange{ChangeType: "replace", Position: pos, Length: length, Content: text}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name   string
+		change models.TextChange
+		want   []string
+	}{
+		{"insert inside", ins(3, "abc"), []string{"0-3:?", "3-6:1", "6-13:?"}},
+		{"insert at start", ins(0, "abc"), []string{"0-3:1", "3-13:?"}},
+		{"insert at end", ins(10, "abc"), []string{"0-10:?", "10-13:1"}},
+		{"delete inside", del(2, 3), []string{"0-7:?"}},
+		{"delete everything", del(0, 10), []string{}},
+		{"replace inside", rep(2, 3, "xy"), []string{"0-2:?", "2-4:1", "4-9:?"}},
+		{"replace to end", rep(6, 4, "xy"), []string{"0-6:?", "6-8:1"}},
+		{"insert in multibyte text counts bytes", ins(1, "é"), []string{"0-1:?", "1-3:1", "3-12:?"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(10, 4)
+			d.Apply(tt.change, first, at, 5)
+			if got := describe(d.Spans); !slices.Equal(got, tt.want) {
+				t.Errorf("spans = %v, want %v", got, tt.want)
+			}
+			if d.Revision != 5 {
+				t.Errorf("revision = %d, want 5", d.Revision)
+			}
+		})
+	}
+}
+
+func TestApplySequence(t *testing.T) {
+	d := New(10, 0)
+	steps := []struct {
+		change models.TextChange
+		id     uuid.UUID
+		want   []string
+	}{
+		{ins(3, "abcdef"), first, []string{"0-3:?", "3-9:1", "9-16:?"}},
+		// Deleting from the middle of a change's text leaves one span
+		{del(4, 2), second, []string{"0-3:?", "3-7:1", "7-14:?"}},
+		{ins(5, "zz"), second, []string{"0-3:?", "3-5:1", "5-7:2", "7-9:1", "9-16:?"}},
+		// Replacing across owners hands the whole range to the new change
+		{rep(4, 6, "q"), second, []string{"0-3:?", "3-4:1", "4-5:2", "5-11:?"}},
+	}
+	for i, step := range steps {
+		step.change.UserName = "ann"
+		d.Apply(step.change, step.id, at, int64(i+1))
+		if got := describe(d.Spans); !slices.Equal(got, step.want) {
+			t.Fatalf("after step %d spans = %v, want %v", i+1, got, step.want)
+		}
+	}
+	for _, span := range d.Spans {
+		if span.ChangeID != nil && (span.UserName != "ann" || !span.Timestamp.Equal(at)) {
+			t.Errorf("span %+v lost its author", span)
+		}
+	}
+}
+
+func TestFit(t *testing.T) {
+	tests := []struct {
+		name   string
+		length int
+		want   []string
+	}{
+		{"exact", 13, []string{"0-3:?", "3-6:1", "6-13:?"}},
+		{"trims into a change", 5, []string{"0-3:?", "3-5:1"}},
+		{"trims at a boundary", 6, []string{"0-3:?", "3-6:1"}},
+		{"extends the unknown tail", 20, []string{"0-3:?", "3-6:1", "6-20:?"}},
+		{"empty", 0, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := New(10, 0)
+			d.Apply(ins(3, "abc"), first, at, 1)
+			d.Fit(tt.length)
+			if got := describe(d.Spans); !slices.Equal(got, tt.want) {
+				t.Errorf("Fit(%d) spans = %v, want %v", tt.length, got, tt.want)
+			}
+		})
+	}
+
+	d := New(3, 0)
+	d.Apply(ins(3, "abc"), first, at, 1)
+	d.Fit(8)
+	if got, want := describe(d.Spans), []string{"0-3:?", "3-6:1", "6-8:?"}; !slices.Equal(got, want) {
+		t.Errorf("Fit after a change spans = %v, want %v", got, want)
+	}
+}
+
+func TestCache(t *testing.T) {
+	id := uuid.New()
+	c := NewCache()
+	if _, ok := c.Get(id); ok {
+		t.Fatal("empty cache returned a document")
+	}
+	c.Apply(id, ins(0, "x"), first, at, 1)
+	if _, ok := c.Get(id); ok {
+		t.Fatal("Apply cached a document nobody put")
+	}
+
+	c.Put(id, New(10, 2))
+	got, ok := c.Get(id)
+	if !ok || got.Revision != 2 {
+		t.Fatalf("Get = %+v, %v, want revision 2", got, ok)
+	}
+	got.Spans[0].End = 99
+	if again, _ := c.Get(id); again.Spans[0].End != 10 {
+		t.Error("Get shares spans with the cache")
+	}
+
+	c.Put(id, New(4, 1))
+	if got, _ := c.Get(id); got.Revision != 2 {
+		t.Errorf("older Put replaced revision 2 with %d", got.Revision)
+	}
+
+	c.Apply(id, ins(0, "abc"), first, at, 3)
+	got, ok = c.Get(id)
+	if want := []string{"0-3:1", "3-13:?"}; !ok || got.Revision != 3 || !slices.Equal(describe(got.Spans), want) {
+		t.Fatalf("after Apply got %+v, want revision 3 with %v", got, want)
+	}
+
+	// Missing revision 4 means the cached spans can no longer be trusted
+	c.Apply(id, ins(0, "abc"), second, at, 5)
+	if _, ok := c.Get(id); ok {
+		t.Error("Apply past a missed revision kept the stale document")
+	}
+}
@@ -1,24 +1,549 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"storychain-backend/internal/origin"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
 )
 
+// Config is the full server configuration. Values come from the defaults
+// below, then an optional YAML or TOML file, then environment variables.
 type Config struct {
-	DatabaseURL string
-	FrontendURL string
+	Server     ServerConfig     `yaml:"server" toml:"server"`
+	Database   DatabaseConfig   `yaml:"database" toml:"database"`
+	Log        LogConfig        `yaml:"log" toml:"log"`
+	WebSocket  WebSocketConfig  `yaml:"websocket" toml:"websocket"`
+	Moderation ModerationConfig `yaml:"moderation" toml:"moderation"`
+	History    HistoryConfig    `yaml:"history" toml:"history"`
+	RateLimit  RateLimitConfig  `yaml:"rate_limit" toml:"rate_limit"`
+	Policy     PolicyConfig     `yaml:"policy" toml:"policy"`
+	Voting     VotingConfig     `yaml:"voting" toml:"voting"`
+	Webhooks   WebhooksConfig   `yaml:"webhooks" toml:"webhooks"`
+}
+
+type ServerConfig struct {
+	Port        int    `yaml:"port" toml:"port"`
+	FrontendURL string `yaml:"frontend_url" toml:"frontend_url"`
+	// AllowedOrigins may hold exact origins and patterns like
+	// "https://*.vercel.app"; when empty only FrontendURL is allowed
+	AllowedOrigins []string `yaml:"allowed_origins" toml:"allowed_origins"`
+	// TrustedProxies may set X-Forwarded-For; when empty the peer address is the client IP
+	TrustedProxies []string `yaml:"trusted_proxies" toml:"trusted_proxies"`
+	// TrustedPlatform names a hosting platform whose client IP header is trusted: flyio, cloudflare or appengine
+	TrustedPlatform   string   `yaml:"trusted_platform" toml:"trusted_platform"`
+	ReadHeaderTimeout Duration `yaml:"read_header_timeout" toml:"read_header_timeout"`
+	// ShutdownTimeout bounds draining; keep it below the platform's kill timeout
+	ShutdownTimeout Duration `yaml:"shutdown_timeout" toml:"shutdown_timeout"`
+	// AdminToken is the bearer token for admin endpoints; empty disables them
+	AdminToken string `yaml:"admin_token" toml:"admin_token"`
+	// InviteSecret signs invite tokens; when empty a random key is used and
+	// invites stop working on restart
+	InviteSecret string `yaml:"invite_secret" toml:"invite_secret"`
+	// SessionSecret signs session tokens; when empty a random key is used and
+	// users get a new identity on restart
+	SessionSecret string   `yaml:"session_secret" toml:"session_secret"`
+	SessionTTL    Duration `yaml:"session_ttl" toml:"session_ttl"`
+}
+
+type DatabaseConfig struct {
+	URL             string   `yaml:"url" toml:"url"`
+	MaxOpenConns    int      `yaml:"max_open_conns" toml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime" toml:"conn_max_lifetime"`
+	ConnMaxIdleTime Duration `yaml:"conn_max_idle_time" toml:"conn_max_idle_time"`
+}
+
+type LogConfig struct {
+	Level  string `yaml:"level" toml:"level"`
+	Format string `yaml:"format" toml:"format"`
+}
+
+type WebSocketConfig struct {
+	EditCooldown    Duration `yaml:"edit_cooldown" toml:"edit_cooldown"`
+	SendBuffer      int      `yaml:"send_buffer" toml:"send_buffer"`
+	BroadcastBuffer int      `yaml:"broadcast_buffer" toml:"broadcast_buffer"`
+	PongWait        Duration `yaml:"pong_wait" toml:"pong_wait"`
+	WriteWait       Duration `yaml:"write_wait" toml:"write_wait"`
+	// SampleInterval is how often the peak number of connected users is
+	// recorded for activity analytics; SampleRetention is how long it is kept
+	SampleInterval  Duration `yaml:"sample_interval" toml:"sample_interval"`
+	SampleRetention Duration `yaml:"sample_retention" toml:"sample_retention"`
+}
+
+type ModerationConfig struct {
+	Enabled  bool     `yaml:"enabled" toml:"enabled"`
+	Endpoint string   `yaml:"endpoint" toml:"endpoint"`
+	Timeout  Duration `yaml:"timeout" toml:"timeout"`
+}
+
+type HistoryConfig struct {
+	// Limit is how many changes getChanges returns
+	Limit int `yaml:"limit" toml:"limit"`
+}
+
+type VotingConfig struct {
+	// RevertThreshold is the net score at or below minus this that reverts a
+	// change; 0 turns automatic reverts off
+	RevertThreshold int `yaml:"revert_threshold" toml:"revert_threshold"`
+	// Window is how long after a change its votes can still revert it
+	Window Duration `yaml:"window" toml:"window"`
+}
+
+type WebhooksConfig struct {
+	// Timeout bounds each delivery attempt, including reading the response
+	Timeout Duration `yaml:"timeout" toml:"timeout"`
+	// MaxAttempts is how many times a delivery is tried before it is marked failed
+	MaxAttempts int `yaml:"max_attempts" toml:"max_attempts"`
+	// RetryBase is the delay after the first failure, doubling up to RetryMax
+	RetryBase Duration `yaml:"retry_base" toml:"retry_base"`
+	RetryMax  Duration `yaml:"retry_max" toml:"retry_max"`
+	// PollInterval is how often the outbox is checked for due deliveries
+	PollInterval Duration `yaml:"poll_interval" toml:"poll_interval"`
+	// LogRetention is how long finished deliveries stay in the delivery log
+	LogRetention Duration `yaml:"log_retention" toml:"log_retention"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool `yaml:"enabled" toml:"enabled"`
+	// Store is "memory" for a single instance or "postgres" to share buckets between instances
+	Store string `yaml:"store" toml:"store"`
+	// Routes maps REST route groups (read, write, connect) to their limits
+	Routes map[string]RateRule `yaml:"routes" toml:"routes"`
+	// WebSocket maps incoming websocket message types to their limits
+	WebSocket map[string]RateRule `yaml:"websocket" toml:"websocket"`
+}
+
+// PolicyConfig holds the content rules every edit must pass. Zero limits
+// are unlimited.
+type PolicyConfig struct {
+	// MaxMessageBytes caps websocket messages
+	MaxMessageBytes int64 `yaml:"max_message_bytes" toml:"max_message_bytes"`
+	// MaxBodyBytes caps REST request bodies, which carry JSON-escaped text
+	// and so need more room than the character limits suggest
+	MaxBodyBytes    int64 `yaml:"max_body_bytes" toml:"max_body_bytes"`
+	MaxInsertLength int   `yaml:"max_insert_length" toml:"max_insert_length"`
+	MaxDeleteLength int   `yaml:"max_delete_length" toml:"max_delete_length"`
+	// MaxWordsPerEdit set to 1 gives the "story chain" single-word mode
+	MaxWordsPerEdit int `yaml:"max_words_per_edit" toml:"max_words_per_edit"`
+	MaxDocumentSize int `yaml:"max_document_size" toml:"max_document_size"`
+	// BannedCharacters lists character classes: control, zero_width, bidi, private_use, emoji
+	BannedCharacters []string `yaml:"banned_characters" toml:"banned_characters"`
+	BlockLinks       bool     `yaml:"block_links" toml:"block_links"`
+}
+
+// RateRule allows Requests per Per on average, with bursts of up to Burst.
+type RateRule struct {
+	Requests int      `yaml:"requests" toml:"requests"`
+	Per      Duration `yaml:"per" toml:"per"`
+	Burst    int      `yaml:"burst" toml:"burst"`
 }
 
-func Load() *Config {
+// Duration is a time.Duration written as "30s" or "5m" in files and env.
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	d.Duration = value
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
 	return &Config{
-		DatabaseURL: getEnv("DATABASE_URL", "postgres://localhost:5432/storychain?sslmode=disable&prefer_simple_protocol=true&statement_cache_mode=none"),
-		FrontendURL: getEnv("FRONTEND_URL", "http://localhost:3000"),
+		Server: ServerConfig{
+			Port:              8080,
+			FrontendURL:       "http://localhost:3000",
+			ReadHeaderTimeout: Duration{10 * time.Second},
+			ShutdownTimeout:   Duration{20 * time.Second},
+			SessionTTL:        Duration{30 * 24 * time.Hour},
+		},
+		Database: DatabaseConfig{
+			URL: "postgres://localhost:5432/storychain?sslmode=disable&prefer_simple_protocol=true&statement_cache_mode=none",
+			// A small pool avoids prepared statement issues behind poolers
+			MaxOpenConns:    5,
+			MaxIdleConns:    1,
+			ConnMaxLifetime: Duration{time.Minute},
+			ConnMaxIdleTime: Duration{30 * time.Second},
+		},
+		Log: LogConfig{
+			Level:  "info",
+			Format: "text",
+		},
+		WebSocket: WebSocketConfig{
+			EditCooldown:    Duration{10 * time.Second},
+			SendBuffer:      256,
+			BroadcastBuffer: 256,
+			PongWait:        Duration{60 * time.Second},
+			WriteWait:       Duration{10 * time.Second},
+			SampleInterval:  Duration{time.Minute},
+			SampleRetention: Duration{90 * 24 * time.Hour},
+		},
+		Moderation: ModerationConfig{
+			Enabled:  true,
+			Endpoint: "https://vector.profanity.dev",
+			Timeout:  Duration{3 * time.Second},
+		},
+		History: HistoryConfig{
+			Limit: 50,
+		},
+		Policy: PolicyConfig{
+			MaxMessageBytes:  512,
+			MaxBodyBytes:     16 << 10,
+			MaxInsertLength:  280,
+			MaxDeleteLength:  280,
+			MaxDocumentSize:  100000,
+			BannedCharacters: []string{"control", "zero_width", "bidi"},
+			BlockLinks:       true,
+		},
+		Voting: VotingConfig{
+			RevertThreshold: 0,
+			Window:          Duration{24 * time.Hour},
+		},
+		Webhooks: WebhooksConfig{
+			Timeout:      Duration{10 * time.Second},
+			MaxAttempts:  8,
+			RetryBase:    Duration{30 * time.Second},
+			RetryMax:     Duration{time.Hour},
+			PollInterval: Duration{5 * time.Second},
+			LogRetention: Duration{30 * 24 * time.Hour},
+		},
+		RateLimit: RateLimitConfig{
+			Enabled: true,
+			Store:   "memory",
+			Routes: map[string]RateRule{
+				"read":    {Requests: 120, Per: Duration{time.Minute}, Burst: 60},
+				"write":   {Requests: 30, Per: Duration{time.Minute}, Burst: 10},
+				"connect": {Requests: 20, Per: Duration{time.Minute}, Burst: 10},
+			},
+			WebSocket: map[string]RateRule{
+				"cursor_position": {Requests: 20, Per: Duration{time.Second}, Burst: 40},
+				"user_update":     {Requests: 10, Per: Duration{time.Minute}, Burst: 5},
+			},
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the file at path (skipped
+// when path is empty) and the environment, and validates the result.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile reads YAML or TOML depending on the extension. Unknown keys are
+// rejected so typos do not silently fall back to defaults.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalWithOptions(data, c, yaml.Strict())
+	case ".toml":
+		decoder := toml.NewDecoder(strings.NewReader(string(data)))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(c)
+	default:
+		return fmt.Errorf("unsupported config file type %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv applies environment overrides. The original variable names
+// (DATABASE_URL, FRONTEND_URL, PORT, ...) keep working.
+func (c *Config) loadEnv() error {
+	var errs []error
+	str := func(key string, dst *string) {
+		if value := os.Getenv(key); value != "" {
+			*dst = value
+		}
+	}
+	integer := func(key string, dst *int) {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
+	}
+	int64Value := func(key string, dst *int64) {
+		if value := os.Getenv(key); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = n
+		}
 	}
+	duration := func(key string, dst *Duration) {
+		if value := os.Getenv(key); value != "" {
+			if err := dst.UnmarshalText([]byte(value)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+			}
+		}
+	}
+	list := func(key string, dst *[]string) {
+		if value := os.Getenv(key); value != "" {
+			*dst = nil
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+	boolean := func(key string, dst *bool) {
+		if value := os.Getenv(key); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", key, err))
+				return
+			}
+			*dst = b
+		}
+	}
+
+	integer("PORT", &c.Server.Port)
+	str("FRONTEND_URL", &c.Server.FrontendURL)
+	list("ALLOWED_ORIGINS", &c.Server.AllowedOrigins)
+	list("TRUSTED_PROXIES", &c.Server.TrustedProxies)
+	str("TRUSTED_PLATFORM", &c.Server.TrustedPlatform)
+	str("ADMIN_TOKEN", &c.Server.AdminToken)
+	str("INVITE_SECRET", &c.Server.InviteSecret)
+	str("SESSION_SECRET", &c.Server.SessionSecret)
+	duration("SESSION_TTL", &c.Server.SessionTTL)
+	duration("READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	duration("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+
+	str("DATABASE_URL", &c.Database.URL)
+	integer("DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	integer("DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+	duration("DB_CONN_MAX_LIFETIME", &c.Database.ConnMaxLifetime)
+	duration("DB_CONN_MAX_IDLE_TIME", &c.Database.ConnMaxIdleTime)
+
+	// PROFANITY_DEBUG predates LOG_LEVEL and still means debug logging
+	switch strings.ToLower(os.Getenv("PROFANITY_DEBUG")) {
+	case "1", "true", "yes":
+		c.Log.Level = "debug"
+	}
+	str("LOG_LEVEL", &c.Log.Level)
+	str("LOG_FORMAT", &c.Log.Format)
+
+	duration("EDIT_COOLDOWN", &c.WebSocket.EditCooldown)
+	integer("WS_SEND_BUFFER", &c.WebSocket.SendBuffer)
+	integer("WS_BROADCAST_BUFFER", &c.WebSocket.BroadcastBuffer)
+	duration("WS_PONG_WAIT", &c.WebSocket.PongWait)
+	duration("WS_WRITE_WAIT", &c.WebSocket.WriteWait)
+	duration("WS_SAMPLE_INTERVAL", &c.WebSocket.SampleInterval)
+	duration("WS_SAMPLE_RETENTION", &c.WebSocket.SampleRetention)
+
+	boolean("MODERATION_ENABLED", &c.Moderation.Enabled)
+	str("MODERATION_ENDPOINT", &c.Moderation.Endpoint)
+	duration("MODERATION_TIMEOUT", &c.Moderation.Timeout)
+
+	integer("HISTORY_LIMIT", &c.History.Limit)
+
+	// WS_READ_LIMIT was the websocket-only name for the message size limit
+	int64Value("WS_READ_LIMIT", &c.Policy.MaxMessageBytes)
+	int64Value("MAX_MESSAGE_BYTES", &c.Policy.MaxMessageBytes)
+	int64Value("MAX_BODY_BYTES", &c.Policy.MaxBodyBytes)
+	integer("MAX_INSERT_LENGTH", &c.Policy.MaxInsertLength)
+	integer("MAX_DELETE_LENGTH", &c.Policy.MaxDeleteLength)
+	integer("MAX_WORDS_PER_EDIT", &c.Policy.MaxWordsPerEdit)
+	integer("MAX_DOCUMENT_SIZE", &c.Policy.MaxDocumentSize)
+	list("BANNED_CHARACTERS", &c.Policy.BannedCharacters)
+	boolean("BLOCK_LINKS", &c.Policy.BlockLinks)
+
+	integer("VOTE_REVERT_THRESHOLD", &c.Voting.RevertThreshold)
+	duration("VOTE_WINDOW", &c.Voting.Window)
+
+	duration("WEBHOOK_TIMEOUT", &c.Webhooks.Timeout)
+	integer("WEBHOOK_MAX_ATTEMPTS", &c.Webhooks.MaxAttempts)
+	duration("WEBHOOK_RETRY_BASE", &c.Webhooks.RetryBase)
+	duration("WEBHOOK_RETRY_MAX", &c.Webhooks.RetryMax)
+	duration("WEBHOOK_POLL_INTERVAL", &c.Webhooks.PollInterval)
+	duration("WEBHOOK_LOG_RETENTION", &c.Webhooks.LogRetention)
+
+	boolean("RATE_LIMIT_ENABLED", &c.RateLimit.Enabled)
+	str("RATE_LIMIT_STORE", &c.RateLimit.Store)
+
+	return errors.Join(errs...)
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Port > 0 && c.Server.Port < 65536, "server.port must be between 1 and 65535, got %d", c.Server.Port)
+	check(c.Server.FrontendURL != "", "server.frontend_url is required")
+	if _, err := origin.NewPolicy(c.Server.Origins()); err != nil {
+		errs = append(errs, fmt.Errorf("server.allowed_origins: %w", err))
+	}
+	switch c.Server.TrustedPlatform {
+	case "", "flyio", "cloudflare", "appengine":
+	default:
+		errs = append(errs, fmt.Errorf("server.trusted_platform must be flyio, cloudflare or appengine, got %q", c.Server.TrustedPlatform))
+	}
+	check(c.Server.ReadHeaderTimeout.Duration > 0, "server.read_header_timeout must be positive")
+	check(c.Server.ShutdownTimeout.Duration > 0, "server.shutdown_timeout must be positive")
+
+	check(c.Database.URL != "", "database.url is required")
+	check(c.Database.MaxOpenConns > 0, "database.max_open_conns must be at least 1")
+	check(c.Database.MaxIdleConns >= 0 && c.Database.MaxIdleConns <= c.Database.MaxOpenConns,
+		"database.max_idle_conns must be between 0 and max_open_conns")
+	check(c.Database.ConnMaxLifetime.Duration >= 0, "database.conn_max_lifetime must not be negative")
+	check(c.Database.ConnMaxIdleTime.Duration >= 0, "database.conn_max_idle_time must not be negative")
+
+	switch strings.ToLower(c.Log.Level) {
+	case "debug", "info", "warn", "warning", "error":
+	default:
+		errs = append(errs, fmt.Errorf("log.level must be debug, info, warn or error, got %q", c.Log.Level))
 	}
-	return defaultValue
-}
\ No newline at end of file
+	switch strings.ToLower(c.Log.Format) {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log.format must be text or json, got %q", c.Log.Format))
+	}
+
+	check(c.WebSocket.EditCooldown.Duration >= 0, "websocket.edit_cooldown must not be negative")
+	check(c.WebSocket.SendBuffer > 0, "websocket.send_buffer must be at least 1")
+	check(c.WebSocket.BroadcastBuffer > 0, "websocket.broadcast_buffer must be at least 1")
+	check(c.WebSocket.PongWait.Duration > 0, "websocket.pong_wait must be positive")
+	check(c.WebSocket.WriteWait.Duration > 0, "websocket.write_wait must be positive")
+	check(c.WebSocket.SampleInterval.Duration >= time.Second, "websocket.sample_interval must be at least 1s")
+	check(c.WebSocket.SampleRetention.Duration >= 24*time.Hour, "websocket.sample_retention must be at least 24h")
+
+	if c.Moderation.Enabled {
+		u, err := url.Parse(c.Moderation.Endpoint)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "",
+			"moderation.endpoint must be an http(s) URL, got %q", c.Moderation.Endpoint)
+		check(c.Moderation.Timeout.Duration > 0, "moderation.timeout must be positive")
+	}
+
+	check(c.History.Limit > 0 && c.History.Limit <= 1000, "history.limit must be between 1 and 1000, got %d", c.History.Limit)
+
+	check(c.Server.AdminToken == "" || len(c.Server.AdminToken) >= 16, "server.admin_token must be at least 16 characters")
+	check(c.Server.InviteSecret == "" || len(c.Server.InviteSecret) >= 32, "server.invite_secret must be at least 32 characters")
+	check(c.Server.SessionSecret == "" || len(c.Server.SessionSecret) >= 32, "server.session_secret must be at least 32 characters")
+	check(c.Server.SessionTTL.Duration > 0, "server.session_ttl must be positive")
+	check(c.Policy.MaxMessageBytes >= 128, "policy.max_message_bytes must be at least 128")
+	check(c.Policy.MaxBodyBytes >= 1024, "policy.max_body_bytes must be at least 1024")
+	check(c.Policy.MaxInsertLength >= 0, "policy.max_insert_length must not be negative")
+	check(c.Policy.MaxDeleteLength >= 0, "policy.max_delete_length must not be negative")
+	check(c.Policy.MaxWordsPerEdit >= 0, "policy.max_words_per_edit must not be negative")
+	check(c.Policy.MaxDocumentSize >= 0, "policy.max_document_size must not be negative")
+	for _, class := range c.Policy.BannedCharacters {
+		switch class {
+		case "control", "zero_width", "bidi", "private_use", "emoji":
+		default:
+			errs = append(errs, fmt.Errorf("policy.banned_characters: unknown class %q", class))
+		}
+	}
+
+	check(c.Voting.RevertThreshold >= 0, "voting.revert_threshold must not be negative")
+	check(c.Voting.Window.Duration > 0, "voting.window must be positive")
+
+	check(c.Webhooks.Timeout.Duration > 0, "webhooks.timeout must be positive")
+	check(c.Webhooks.MaxAttempts >= 1, "webhooks.max_attempts must be at least 1")
+	check(c.Webhooks.RetryBase.Duration > 0, "webhooks.retry_base must be positive")
+	check(c.Webhooks.RetryMax.Duration >= c.Webhooks.RetryBase.Duration, "webhooks.retry_max must be at least retry_base")
+	check(c.Webhooks.PollInterval.Duration >= 100*time.Millisecond, "webhooks.poll_interval must be at least 100ms")
+	check(c.Webhooks.LogRetention.Duration >= 24*time.Hour, "webhooks.log_retention must be at least 24h")
+
+	if c.RateLimit.Enabled {
+		check(c.RateLimit.Store == "memory" || c.RateLimit.Store == "postgres",
+			"rate_limit.store must be memory or postgres, got %q", c.RateLimit.Store)
+		for name, rule := range c.RateLimit.Routes {
+			if err := rule.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("rate_limit.routes.%s: %w", name, err))
+			}
+		}
+		for name, rule := range c.RateLimit.WebSocket {
+			if err := rule.validate(); err != nil {
+				errs = append(errs, fmt.Errorf("rate_limit.websocket.%s: %w", name, err))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (r RateRule) validate() error {
+	if r.Requests < 1 || r.Per.Duration <= 0 || r.Burst < 1 {
+		return fmt.Errorf("requests and burst must be at least 1 and per must be positive")
+	}
+	return nil
+}
+
+// Origins returns the origins browsers may call the API from.
+func (s ServerConfig) Origins() []string {
+	if len(s.AllowedOrigins) > 0 {
+		return s.AllowedOrigins
+	}
+	return []string{s.FrontendURL}
+}
+
+// Redacted returns the configuration as YAML with secrets masked, for
+// --print-config.
+func (c *Config) Redacted() ([]byte, error) {
+	copied := *c
+	if u, err := url.Parse(c.Database.URL); err == nil {
+		copied.Database.URL = u.Redacted()
+	}
+	if copied.Server.AdminToken != "" {
+		copied.Server.AdminToken = "xxxxx"
+	}
+	if copied.Server.InviteSecret != "" {
+		copied.Server.InviteSecret = "xxxxx"
+	}
+	if copied.Server.SessionSecret != "" {
+		copied.Server.SessionSecret = "xxxxx"
+	}
+	return yaml.Marshal(copied)
+}
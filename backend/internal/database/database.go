@@ -3,26 +3,27 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"time"
+
+	"storychain-backend/internal/config"
 
 	_ "github.com/lib/pq"
 )
 
-func Connect(databaseURL string) (*sql.DB, error) {
-	db, err := sql.Open("postgres", databaseURL)
+func Connect(cfg config.DatabaseConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", cfg.URL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
 
 	// Configure connection pool to prevent prepared statement issues
-	db.SetMaxOpenConns(5)
-	db.SetMaxIdleConns(1)
-	db.SetConnMaxLifetime(1 * time.Minute)
-	db.SetConnMaxIdleTime(30 * time.Second)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime.Duration)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime.Duration)
 
 	if err := db.Ping(); err != nil {
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 
 	return db, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,283 @@
+// Package diff compares two versions of a text by lines or by words.
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Line operations, as prefixed in unified diffs.
+const (
+	OpEqual  = " "
+	OpDelete = "-"
+	OpInsert = "+"
+)
+
+// maxEdits bounds the work Compute does; texts that differ by more are
+// reported as replaced outright. The walk back keeps every step's frontier,
+// so memory grows with the square of this: about 8MB at 1000.
+const maxEdits = 1000
+
+// MaxTokens is the most tokens either text may have for Compute to be
+// worth running; callers should split more coarsely or refuse above it.
+const MaxTokens = 50000
+
+// ErrTooLarge is returned by Tokens for texts with more than MaxTokens lines.
+var ErrTooLarge = errors.New("text has too many lines to diff")
+
+// Line is one token of an edit script: a line, or a word with the
+// whitespace after it.
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Hunk is a run of changes with context, numbered from 1 like unified diffs.
+type Hunk struct {
+	FromStart int    `json:"from_start"`
+	FromCount int    `json:"from_count"`
+	ToStart   int    `json:"to_start"`
+	ToCount   int    `json:"to_count"`
+	Lines     []Line `json:"lines"`
+}
+
+// Lines splits text into lines, keeping their newlines.
+func Lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Words splits text into words, each with the whitespace that follows it.
+// Leading whitespace is a token of its own.
+func Words(text string) []string {
+	var words []string
+	start := 0
+	inSpace := true
+	for i, r := range text {
+		space := unicode.IsSpace(r)
+		if !space && inSpace && i > start {
+			words = append(words, text[start:i])
+			start = i
+		}
+		inSpace = space
+	}
+	if start < len(text) {
+		words = append(words, text[start:])
+	}
+	return words
+}
+
+// Tokens splits two texts for Compute: by word when byWord is set, falling
+// back to lines when either text has more than MaxTokens words. It reports
+// whether it split by word, and ErrTooLarge if either text has more than
+// MaxTokens lines.
+func Tokens(from, to string, byWord bool) (a, b []string, words bool, err error) {
+	if byWord {
+		a, b = Words(from), Words(to)
+		if max(len(a), len(b)) <= MaxTokens {
+			return a, b, true, nil
+		}
+	}
+	a, b = Lines(from), Lines(to)
+	if max(len(a), len(b)) > MaxTokens {
+		return nil, nil, false, ErrTooLarge
+	}
+	return a, b, false, nil
+}
+
+// Compute returns an edit script turning a into b.
+func Compute(a, b []string) []Line {
+	// Common ends are cheap to strip and keep the search small
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	script := make([]Line, 0, len(a)+len(b))
+	for _, text := range a[:prefix] {
+		script = append(script, Line{OpEqual, text})
+	}
+	script = append(script, myers(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, text := range a[len(a)-suffix:] {
+		script = append(script, Line{OpEqual, text})
+	}
+	return script
+}
+
+// myers finds a shortest edit script with Myers' algorithm, keeping only the
+// frontier of each step for the walk back.
+func myers(a, b []string) []Line {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace)
+			}
+		}
+	}
+
+	// Too different to be worth aligning
+	script := make([]Line, 0, n+m)
+	for _, text := range a {
+		script = append(script, Line{OpDelete, text})
+	}
+	for _, text := range b {
+		script = append(script, Line{OpInsert, text})
+	}
+	return script
+}
+
+func backtrack(a, b []string, trace [][]int) []Line {
+	x, y := len(a), len(b)
+	var reversed []Line
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		at := func(k int) int { return v[k+d] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			reversed = append(reversed, Line{OpEqual, a[x]})
+		}
+		if x == prevX {
+			y--
+			reversed = append(reversed, Line{OpInsert, b[y]})
+		} else {
+			x--
+			reversed = append(reversed, Line{OpDelete, a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		reversed = append(reversed, Line{OpEqual, a[x]})
+	}
+
+	script := make([]Line, len(reversed))
+	for i, line := range reversed {
+		script[len(reversed)-1-i] = line
+	}
+	return script
+}
+
+// Hunks groups an edit script into hunks with up to context unchanged lines
+// around each change.
+func Hunks(script []Line, context int) []Hunk {
+	var hunks []Hunk
+	from, to := 0, 0 // lines of each side before script[i]
+	for i := 0; i < len(script); {
+		if script[i].Op == OpEqual {
+			from++
+			to++
+			i++
+			continue
+		}
+
+		// Start with the context before the change, then extend while
+		// changes are no more than 2*context lines apart
+		start := max(i-context, 0)
+		for j := start; j < i; j++ {
+			from--
+			to--
+		}
+		hunk := Hunk{FromStart: from, ToStart: to}
+		end := i
+		for end < len(script) {
+			if script[end].Op != OpEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(script) && script[run].Op == OpEqual {
+				run++
+			}
+			if run == len(script) || run-end > 2*context {
+				end = min(end+context, len(script))
+				break
+			}
+			end = run
+		}
+		hunk.Lines = script[start:end]
+		for _, line := range hunk.Lines {
+			if line.Op != OpInsert {
+				hunk.FromCount++
+				from++
+			}
+			if line.Op != OpDelete {
+				hunk.ToCount++
+				to++
+			}
+		}
+		// Unified diffs number an empty side by the line before it
+		if hunk.FromCount > 0 {
+			hunk.FromStart++
+		}
+		if hunk.ToCount > 0 {
+			hunk.ToStart++
+		}
+		hunks = append(hunks, hunk)
+		i = end
+	}
+	return hunks
+}
+
+// Unified renders hunks as a unified diff. In a word diff each token is a
+// line of the output, with the newlines inside it written as \n.
+func Unified(fromLabel, toLabel string, hunks []Hunk, byWord bool) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromLabel, toLabel)
+	for _, hunk := range hunks {
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunk.FromStart, hunk.FromCount, hunk.ToStart, hunk.ToCount)
+		for _, line := range hunk.Lines {
+			if byWord {
+				out.WriteString(line.Op + strings.ReplaceAll(line.Text, "\n", `\n`) + "\n")
+				continue
+			}
+			text, newline := strings.CutSuffix(line.Text, "\n")
+			out.WriteString(line.Op + text + "\n")
+			if !newline {
+				out.WriteString("\\ No newline at end of file\n")
+			}
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,171 @@
+package diff
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// sides rebuilds both texts an edit script compares.
+func sides(script []Line) (from, to []string) {
+	for _, line := range script {
+		if line.Op != OpInsert {
+			from = append(from, line.Text)
+		}
+		if line.Op != OpDelete {
+			to = append(to, line.Text)
+		}
+	}
+	return from, to
+}
+
+func edits(script []Line) int {
+	n := 0
+	for _, line := range script {
+		if line.Op != OpEqual {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLinesAndWords(t *testing.T) {
+	tests := []struct {
+		text  string
+		lines []string
+		words []string
+	}{
+		{"", nil, nil},
+		{"one", []string{"one"}, []string{"one"}},
+		{"a b\nc\n", []string{"a b\n", "c\n"}, []string{"a ", "b\n", "c\n"}},
+		{"  lead", []string{"  lead"}, []string{"  ", "lead"}},
+		{"café au lait", []string{"café au lait"}, []string{"café ", "au ", "lait"}},
+	}
+	for _, tt := range tests {
+		if got := Lines(tt.text); !slices.Equal(got, tt.lines) {
+			t.Errorf("Lines(%q) = %q, want %q", tt.text, got, tt.lines)
+		}
+		if got := Words(tt.text); !slices.Equal(got, tt.words) {
+			t.Errorf("Words(%q) = %q, want %q", tt.text, got, tt.words)
+		}
+	}
+}
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		name  string
+		a, b  string
+		edits int
+	}{
+		{"equal", "a b c", "a b c", 0},
+		{"both empty", "", "", 0},
+		{"from empty", "", "a b", 2},
+		{"to empty", "a b", "", 2},
+		{"one word changed", "the quick fox", "the slow fox", 2},
+		{"word inserted", "a c", "a b c", 1},
+		{"word removed", "a b c d", "a c d", 1},
+		{"moved word", "a b c d", "b c d a", 2},
+		{"interleaved", "a b c d e f", "a x c y e z", 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := strings.Fields(tt.a), strings.Fields(tt.b)
+			script := Compute(a, b)
+			from, to := sides(script)
+			if !slices.Equal(from, a) || !slices.Equal(to, b) {
+				t.Fatalf("script rebuilds %q and %q, want %q and %q", from, to, a, b)
+			}
+			if got := edits(script); got != tt.edits {
+				t.Errorf("script has %d edits, want %d", got, tt.edits)
+			}
+		})
+	}
+}
+
+func TestComputeGivesUpPastMaxEdits(t *testing.T) {
+	a := make([]string, maxEdits)
+	b := make([]string, maxEdits)
+	for i := range a {
+		a[i], b[i] = "a", "b"
+	}
+	// A shared line in the middle would align if the search went far enough
+	a[maxEdits/2], b[maxEdits/2] = "same", "same"
+
+	script := Compute(a, b)
+	from, to := sides(script)
+	if !slices.Equal(from, a) || !slices.Equal(to, b) {
+		t.Fatal("script does not rebuild both texts")
+	}
+	if got, want := edits(script), 2*maxEdits; got != want {
+		t.Errorf("script has %d edits, want every line replaced (%d)", got, want)
+	}
+	for i, line := range script {
+		want := OpDelete
+		if i >= len(a) {
+			want = OpInsert
+		}
+		if line.Op != want {
+			t.Fatalf("line %d is %q, want %q (all deletes, then all inserts)", i, line.Op, want)
+		}
+	}
+}
+
+func TestTokens(t *testing.T) {
+	manyWords := strings.Repeat("w ", MaxTokens+1)
+	manyLines := strings.Repeat("l\n", MaxTokens+1)
+	tests := []struct {
+		name     string
+		from, to string
+		byWord   bool
+		words    bool
+		err      error
+	}{
+		{"lines", "a b\n", "a c\n", false, false, nil},
+		{"words", "a b\n", "a c\n", true, true, nil},
+		{"words at the cap", strings.Repeat("w ", MaxTokens), "", true, true, nil},
+		{"too many words falls back to lines", manyWords, "w\n", true, false, nil},
+		{"too many lines", manyLines, "", false, false, ErrTooLarge},
+		{"too many lines by word", "a", manyLines, true, false, ErrTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, words, err := Tokens(tt.from, tt.to, tt.byWord)
+			if err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+			if words != tt.words {
+				t.Errorf("words = %v, want %v", words, tt.words)
+			}
+		})
+	}
+}
+
+func TestHunksAndUnified(t *testing.T) {
+	from := Lines("1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n")
+	to := Lines("1\n2\nthree\n4\n5\n6\n7\n8\n9\n10\n11")
+	hunks := Hunks(Compute(from, to), 1)
+	want := []struct{ fromStart, fromCount, toStart, toCount int }{
+		{2, 3, 2, 3},
+		{10, 1, 10, 2},
+	}
+	if len(hunks) != len(want) {
+		t.Fatalf("got %d hunks, want %d", len(hunks), len(want))
+	}
+	for i, w := range want {
+		h := hunks[i]
+		if h.FromStart != w.fromStart || h.FromCount != w.fromCount || h.ToStart != w.toStart || h.ToCount != w.toCount {
+			t.Errorf("hunk %d = -%d,%d +%d,%d, want -%d,%d +%d,%d", i,
+				h.FromStart, h.FromCount, h.ToStart, h.ToCount, w.fromStart, w.fromCount, w.toStart, w.toCount)
+		}
+	}
+
+	unified := Unified("a", "b", hunks, false)
+	for _, line := range []string{"--- a\n+++ b\n", "@@ -2,3 +2,3 @@\n", "-3\n+three\n", "+11\n\\ No newline at end of file\n"} {
+		if !strings.Contains(unified, line) {
+			t.Errorf("unified diff lacks %q:\n%s", line, unified)
+		}
+	}
+	if Unified("a", "b", nil, false) != "" {
+		t.Error("unified diff of no hunks is not empty")
+	}
+}
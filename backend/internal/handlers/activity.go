@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// activityPruneInterval is how often old activity samples are deleted.
+const activityPruneInterval = time.Hour
+
+// maxTimeseriesBuckets bounds how many buckets one request may ask for.
+const maxTimeseriesBuckets = 1000
+
+// timeseriesBuckets are the supported bucket sizes and the range each
+// covers by default.
+var timeseriesBuckets = map[string]struct {
+	step time.Duration
+	span time.Duration
+}{
+	"hour": {time.Hour, 48 * time.Hour},
+	"day":  {24 * time.Hour, 30 * 24 * time.Hour},
+}
+
+// SampleActivity records the hub's peak number of connected users every
+// sample interval until ctx is cancelled, and deletes samples past their
+// retention.
+func (h *Handler) SampleActivity(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.WebSocket.SampleInterval.Duration)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			h.sampleActivity(ctx, now)
+			if now.Sub(lastPrune) >= activityPruneInterval {
+				lastPrune = now
+				if _, err := h.db.ExecContext(ctx,
+					"DELETE FROM activity_samples WHERE sampled_at < $1",
+					now.Add(-h.cfg.WebSocket.SampleRetention.Duration),
+				); err != nil {
+					h.log.Warn("failed to prune activity samples", "error", err)
+				}
+			}
+		}
+	}
+}
+
+func (h *Handler) sampleActivity(ctx context.Context, now time.Time) {
+	total, byDocument := h.hub.TakePeaks()
+	if _, err := h.db.ExecContext(ctx,
+		"INSERT INTO activity_samples (sampled_at, document_id, peak_users, instance_id) VALUES ($1, NULL, $2, $3)",
+		now, total, h.instanceID.String(),
+	); err != nil {
+		h.log.Warn("failed to record activity sample", "error", err)
+		return
+	}
+	for documentID, peak := range byDocument {
+		if peak == 0 {
+			continue
+		}
+		if _, err := h.db.ExecContext(ctx,
+			"INSERT INTO activity_samples (sampled_at, document_id, peak_users, instance_id) VALUES ($1, $2, $3, $4)",
+			now, documentID.String(), peak, h.instanceID.String(),
+		); err != nil {
+			h.log.Warn("failed to record activity sample", "document_id", documentID, "error", err)
+		}
+	}
+}
+
+func (h *Handler) getTimeseries(c *gin.Context) {
+	logger := h.logger(c)
+	bucket := c.DefaultQuery("bucket", "hour")
+	size, ok := timeseriesBuckets[bucket]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bucket must be hour or day"})
+		return
+	}
+
+	to := time.Now().UTC()
+	if value := c.Query("to"); value != "" {
+		t, err := parseSearchTime(value, true)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date or an RFC 3339 time"})
+			return
+		}
+		to = t.UTC()
+	}
+	from := to.Add(-size.span)
+	if value := c.Query("from"); value != "" {
+		t, err := parseSearchTime(value, false)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date or an RFC 3339 time"})
+			return
+		}
+		from = t.UTC()
+	}
+	from = from.Truncate(size.step)
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must be after from"})
+		return
+	}
+	if to.Sub(from)/size.step >= maxTimeseriesBuckets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d buckets can be requested at once", maxTimeseriesBuckets)})
+		return
+	}
+
+	// Without a document the series covers the whole server
+	args := []any{from, to}
+	changeFilter, sampleFilter := "", " AND document_id IS NULL"
+	var documentID uuid.UUID
+	if value := c.Query("document_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+			return
+		}
+		if _, ok := h.authorize(c, id, requestUser(c), auth.RoleViewer); !ok {
+			return
+		}
+		documentID = id
+		args = append(args, id.String())
+		changeFilter, sampleFilter = " AND document_id = $3", " AND document_id = $3"
+	}
+
+	buckets := []models.ActivityBucket{}
+	index := map[int64]int{}
+	for start := from; start.Before(to); start = start.Add(size.step) {
+		index[start.Unix()] = len(buckets)
+		buckets = append(buckets, models.ActivityBucket{Start: start})
+	}
+
+	rows, err := h.db.Query(fmt.Sprintf(
+		`SELECT date_trunc('%s', timestamp AT TIME ZONE 'UTC'), COUNT(*), COUNT(DISTINCT user_id)
+		FROM changes WHERE timestamp >= $1 AND timestamp < $2 AND user_id <> '%s'%s GROUP BY 1`,
+		bucket, uuid.Nil, changeFilter,
+	), args...)
+	if err != nil {
+		logger.Error("failed to query edit activity", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get activity"})
+		return
+	}
+	for rows.Next() {
+		var start time.Time
+		var edits, editors int
+		if err := rows.Scan(&start, &edits, &editors); err != nil {
+			logger.Error("failed to scan edit activity", "error", err)
+			continue
+		}
+		if i, ok := index[start.Unix()]; ok {
+			buckets[i].Edits, buckets[i].UniqueEditors = edits, editors
+		}
+	}
+	rows.Close()
+
+	// Instances sample separately, so take each instance's peak in a minute,
+	// add those up and then take the peak of the bucket
+	rows, err = h.db.Query(fmt.Sprintf(
+		`SELECT date_trunc('%s', minute), MAX(users) FROM (
+			SELECT minute, SUM(peak) AS users FROM (
+				SELECT date_trunc('minute', sampled_at AT TIME ZONE 'UTC') AS minute, instance_id, MAX(peak_users) AS peak
+				FROM activity_samples WHERE sampled_at >= $1 AND sampled_at < $2%s GROUP BY 1, 2
+			) per_instance GROUP BY 1
+		) per_minute GROUP BY 1`,
+		bucket, sampleFilter,
+	), args...)
+	if err != nil {
+		logger.Error("failed to query connection activity", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get activity"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var start time.Time
+		var peak int
+		if err := rows.Scan(&start, &peak); err != nil {
+			logger.Error("failed to scan connection activity", "error", err)
+			continue
+		}
+		if i, ok := index[start.Unix()]; ok {
+			buckets[i].PeakUsers = peak
+		}
+	}
+
+	response := gin.H{"bucket": bucket, "from": from, "to": to, "buckets": buckets}
+	if documentID != uuid.Nil {
+		response["document_id"] = documentID
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/config"
+	"storychain-backend/internal/metrics"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// APIKeyHeader carries a bot's API key.
+const APIKeyHeader = "X-API-Key"
+
+// apiKeyContextKey is where a request's verified API key is kept.
+const apiKeyContextKey = "api_key"
+
+// Per-key limits in requests per minute, by default and at most.
+const (
+	defaultAPIKeyRateLimit = 60
+	maxAPIKeyRateLimit     = 6000
+)
+
+// maxAPIKeyNameLength caps bot names, which are shown as the author of their edits.
+const maxAPIKeyNameLength = 50
+
+// apiKeyTouchInterval is how stale last_used_at may get before a request
+// updates it.
+const apiKeyTouchInterval = time.Minute
+
+const apiKeyColumns = "id, name, bot_user_id, prefix, scopes, rate_limit, created_at, last_used_at, revoked_at"
+
+func scanAPIKey(row interface{ Scan(...any) error }) (models.APIKey, error) {
+	var key models.APIKey
+	var lastUsed, revoked sql.NullTime
+	err := row.Scan(
+		&key.ID, &key.Name, &key.BotUserID, &key.Prefix, pq.Array(&key.Scopes),
+		&key.RateLimit, &key.CreatedAt, &lastUsed, &revoked,
+	)
+	if lastUsed.Valid {
+		key.LastUsedAt = &lastUsed.Time
+	}
+	if revoked.Valid {
+		key.RevokedAt = &revoked.Time
+	}
+	return key, err
+}
+
+// requestAPIKey returns the API key a request was verified with, if any.
+func requestAPIKey(c *gin.Context) (models.APIKey, bool) {
+	value, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return models.APIKey{}, false
+	}
+	key, ok := value.(models.APIKey)
+	return key, ok
+}
+
+// identify wraps a route's rate limit middleware to establish who is
+// calling. Requests sending an API key are checked for scope and against
+// the key's own limit, and then act as the key's bot user; others act as
+// the user of their session token, if any. An empty scope means the route
+// does not take API keys.
+func (h *Handler) identify(scope string, next gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader(APIKeyHeader)
+		if token == "" {
+			if !h.verifySession(c) {
+				return
+			}
+			c.Set(ratelimit.UserKey, requestUser(c))
+			next(c)
+			return
+		}
+		if scope == "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "API keys cannot be used here",
+				"code":  "api_key_not_allowed",
+			})
+			return
+		}
+
+		key, err := h.lookupAPIKey(token)
+		if err == sql.ErrNoRows {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key", "code": "invalid_api_key"})
+			return
+		} else if err != nil {
+			h.logger(c).Error("failed to look up API key", "error", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API key"})
+			return
+		}
+		if !slices.Contains(key.Scopes, scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error":    "This API key lacks the scope for this request",
+				"code":     "insufficient_scope",
+				"required": scope,
+			})
+			return
+		}
+		if h.cfg.RateLimit.Enabled {
+			rule := config.RateRule{Requests: key.RateLimit, Per: config.Duration{Duration: time.Minute}, Burst: key.RateLimit}
+			decision := h.limiter.Take(c.Request.Context(), "apikey:"+key.ID.String(), rule)
+			if !decision.Allowed {
+				metrics.RateLimitRejections.WithLabelValues("api_key", scope).Inc()
+				h.logger(c).Info("rate limited", "api_key_id", key.ID)
+				tooManyRequests(c, "Too many requests", "", decision.RetryAfter)
+				return
+			}
+		}
+
+		c.Set(apiKeyContextKey, key)
+		c.Set(ratelimit.UserKey, key.BotUserID)
+		next(c)
+	}
+}
+
+// tooManyRequests aborts with a 429 telling the caller when to retry.
+func tooManyRequests(c *gin.Context, message, code string, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	body := gin.H{"error": message, "retry_after_seconds": seconds}
+	if code != "" {
+		body["code"] = code
+	}
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, body)
+}
+
+// lookupAPIKey finds an unrevoked key by its secret, noting that it was used.
+func (h *Handler) lookupAPIKey(token string) (models.APIKey, error) {
+	key, err := scanAPIKey(h.db.QueryRow(
+		"SELECT "+apiKeyColumns+" FROM api_keys WHERE secret_hash = $1 AND revoked_at IS NULL",
+		auth.HashAPIKey(token),
+	))
+	if err != nil {
+		return key, err
+	}
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) > apiKeyTouchInterval {
+		if _, err := h.db.Exec("UPDATE api_keys SET last_used_at = now() WHERE id = $1", key.ID.String()); err != nil {
+			h.log.Warn("failed to record API key use", "api_key_id", key.ID, "error", err)
+		}
+	}
+	return key, nil
+}
+
+// takeEditCooldown holds bot edits to EDIT_COOLDOWN between edits, writing
+// a 429 while it runs.
+func (h *Handler) takeEditCooldown(c *gin.Context, key models.APIKey) bool {
+	cooldown := h.cfg.WebSocket.EditCooldown.Duration
+	if cooldown <= 0 {
+		return true
+	}
+	rule := config.RateRule{Requests: 1, Per: config.Duration{Duration: cooldown}, Burst: 1}
+	decision := h.limiter.Take(c.Request.Context(), "cooldown:apikey:"+key.ID.String(), rule)
+	if decision.Allowed {
+		return true
+	}
+	metrics.CooldownRejections.Inc()
+	tooManyRequests(c, "Wait for the edit cooldown", "cooldown", decision.RetryAfter)
+	return false
+}
+
+func (h *Handler) getAPIKeys(c *gin.Context) {
+	if !h.requireAdmin(c) {
+		return
+	}
+	rows, err := h.db.Query("SELECT " + apiKeyColumns + " FROM api_keys ORDER BY created_at DESC")
+	if err != nil {
+		h.logger(c).Error("failed to query API keys", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get API keys"})
+		return
+	}
+	defer rows.Close()
+
+	keys := []models.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			h.logger(c).Error("failed to scan API key", "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"api_keys": keys})
+}
+
+func (h *Handler) createAPIKey(c *gin.Context) {
+	logger := h.logger(c)
+	if !h.requireAdmin(c) {
+		return
+	}
+
+	var req struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		RateLimit int      `json:"rate_limit"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || utf8.RuneCountInString(req.Name) > maxAPIKeyNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Name must be 1 to %d characters", maxAPIKeyNameLength)})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		req.Scopes = []string{auth.ScopeRead}
+	}
+	for _, scope := range req.Scopes {
+		if !auth.ValidScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scopes must be read or write"})
+			return
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+	if req.RateLimit == 0 {
+		req.RateLimit = defaultAPIKeyRateLimit
+	}
+	if req.RateLimit < 1 || req.RateLimit > maxAPIKeyRateLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Rate limit must be between 1 and %d requests per minute", maxAPIKeyRateLimit)})
+		return
+	}
+
+	token, prefix := auth.NewAPIKey()
+	key := models.APIKey{
+		ID:        uuid.New(),
+		Name:      req.Name,
+		BotUserID: uuid.New(),
+		Prefix:    prefix,
+		Scopes:    req.Scopes,
+		RateLimit: req.RateLimit,
+	}
+	err := h.db.QueryRow(
+		`INSERT INTO api_keys (id, name, bot_user_id, prefix, secret_hash, scopes, rate_limit)
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at`,
+		key.ID.String(), key.Name, key.BotUserID.String(), key.Prefix, auth.HashAPIKey(token),
+		pq.Array(key.Scopes), key.RateLimit,
+	).Scan(&key.CreatedAt)
+	if err != nil {
+		logger.Error("failed to create API key", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key"})
+		return
+	}
+
+	logger.Info("API key created", "api_key_id", key.ID, "bot_user_id", key.BotUserID, "scopes", strings.Join(key.Scopes, ","))
+	c.JSON(http.StatusCreated, gin.H{"api_key": key, "key": token})
+}
+
+func (h *Handler) revokeAPIKey(c *gin.Context) {
+	logger := h.logger(c)
+	keyID, err := uuid.Parse(c.Param("keyId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+	if !h.requireAdmin(c) {
+		return
+	}
+
+	result, err := h.db.Exec("UPDATE api_keys SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL", keyID.String())
+	if err != nil {
+		logger.Error("failed to revoke API key", "api_key_id", keyID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+		return
+	}
+
+	logger.Info("API key revoked", "api_key_id", keyID)
+	c.Status(http.StatusNoContent)
+}
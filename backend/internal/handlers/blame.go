@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/blame"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/textops"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// replayBlame rebuilds a document's authorship from its change log. Text
+// that predates the recorded revisions is left without a change.
+func (h *Handler) replayBlame(documentID uuid.UUID) (*blame.Doc, error) {
+	var length int
+	var revision int64
+	err := h.db.QueryRow(
+		"SELECT OCTET_LENGTH(COALESCE(content, '')), revision FROM documents WHERE id = $1",
+		documentID.String(),
+	).Scan(&length, &revision)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.Query(
+		`SELECT id, user_id, user_name, change_type, content, position, length, timestamp, revision
+		FROM changes WHERE document_id = $1 AND revision IS NOT NULL AND revision <= $2 ORDER BY revision`,
+		documentID.String(), revision,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type committed struct {
+		change   models.TextChange
+		id       uuid.UUID
+		at       time.Time
+		revision int64
+	}
+	var history []committed
+	growth := 0
+	for rows.Next() {
+		var entry committed
+		err := rows.Scan(
+			&entry.id, &entry.change.UserID, &entry.change.UserName, &entry.change.ChangeType, &entry.change.Content,
+			&entry.change.Position, &entry.change.Length, &entry.at, &entry.revision,
+		)
+		if err != nil {
+			return nil, err
+		}
+		growth += textops.Delta(entry.change)
+		history = append(history, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Whatever the changes do not account for was there before them
+	current := max(length-growth, 0)
+	doc := blame.New(current, 0)
+	for _, entry := range history {
+		change := textops.Normalize(entry.change, current)
+		doc.Apply(change, entry.id, entry.at, entry.revision)
+		current += textops.Delta(change)
+	}
+	doc.Fit(length)
+	doc.Revision = revision
+	return doc, nil
+}
+
+func (h *Handler) getBlame(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
+
+	// The cache only sees this instance's commits, so check it is current
+	var revision int64
+	err = h.db.QueryRow("SELECT revision FROM documents WHERE id = $1", documentID.String()).Scan(&revision)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		h.logger(c).Error("failed to get document revision", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blame"})
+		return
+	}
+
+	doc, ok := h.blame.Get(documentID)
+	if !ok || doc.Revision != revision {
+		replayed, err := h.replayBlame(documentID)
+		if err == sql.ErrNoRows {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+			return
+		} else if err != nil {
+			h.logger(c).Error("failed to replay blame", "document_id", documentID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get blame"})
+			return
+		}
+		h.blame.Put(documentID, replayed)
+		doc = *replayed
+	}
+
+	if doc.Spans == nil {
+		doc.Spans = []models.BlameSpan{}
+	}
+	c.JSON(http.StatusOK, gin.H{"document_id": documentID, "revision": doc.Revision, "spans": doc.Spans})
+}
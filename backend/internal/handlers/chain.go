@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/policy"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Bounds for the turn length of a chain-mode document, in seconds.
+const (
+	minTurnSeconds = 10
+	maxTurnSeconds = 3600
+)
+
+// defaultChain matches the column defaults of a new document.
+var defaultChain = models.ChainSettings{Unit: policy.ChainUnitWord, TurnSeconds: 60}
+
+// loadChains restores turn taking for documents already in chain mode.
+func (h *Handler) loadChains() {
+	rows, err := h.db.Query("SELECT id, turn_seconds FROM documents WHERE chain_mode")
+	if err != nil {
+		h.log.Error("failed to load chain documents", "error", err)
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var documentID uuid.UUID
+		var turnSeconds int
+		if err := rows.Scan(&documentID, &turnSeconds); err != nil {
+			h.log.Error("failed to scan chain document", "error", err)
+			continue
+		}
+		h.hub.SetChain(documentID, true, time.Duration(turnSeconds)*time.Second)
+	}
+}
+
+func (h *Handler) updateChain(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	settings := defaultChain
+	if !h.bindJSON(c, &settings) {
+		return
+	}
+	if settings.Unit != policy.ChainUnitWord && settings.Unit != policy.ChainUnitSentence {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unit must be word or sentence"})
+		return
+	}
+	if settings.TurnSeconds < minTurnSeconds || settings.TurnSeconds > maxTurnSeconds {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Turn length must be between %d and %d seconds", minTurnSeconds, maxTurnSeconds),
+		})
+		return
+	}
+
+	result, err := h.db.Exec(
+		"UPDATE documents SET chain_mode = $1, chain_unit = $2, turn_seconds = $3 WHERE id = $4",
+		settings.Enabled, settings.Unit, settings.TurnSeconds, documentID.String(),
+	)
+	if err != nil {
+		logger.Error("failed to update chain settings", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chain settings"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	h.hub.SetChain(documentID, settings.Enabled, time.Duration(settings.TurnSeconds)*time.Second)
+	logger.Info("chain settings updated",
+		"document_id", documentID,
+		"enabled", settings.Enabled,
+		"unit", settings.Unit,
+		"turn_seconds", settings.TurnSeconds,
+	)
+	c.JSON(http.StatusOK, gin.H{"chain": settings, "turn": h.hub.Turn(documentID)})
+}
+
+func (h *Handler) getTurn(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, h.hub.Turn(documentID))
+}
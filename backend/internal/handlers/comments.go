@@ -0,0 +1,328 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/textops"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxCommentLength caps comment bodies, in bytes.
+const maxCommentLength = 2000
+
+const commentColumns = "id, document_id, start_pos, end_pos, author_id, author_name, body, resolved, created_at, updated_at"
+
+func scanComment(row interface{ Scan(...any) error }) (models.Comment, error) {
+	var comment models.Comment
+	err := row.Scan(
+		&comment.ID, &comment.DocumentID, &comment.Start, &comment.End, &comment.AuthorID,
+		&comment.AuthorName, &comment.Body, &comment.Resolved, &comment.CreatedAt, &comment.UpdatedAt,
+	)
+	return comment, err
+}
+
+// shiftComments moves the anchors of a document's comments across a
+// normalized change, returning the ones that moved.
+func (h *Handler) shiftComments(q queryer, documentID uuid.UUID, change models.TextChange) ([]models.CommentAnchor, error) {
+	rows, err := q.Query("SELECT id, start_pos, end_pos FROM comments WHERE document_id = $1", documentID.String())
+	if err != nil {
+		return nil, err
+	}
+	var anchors []models.CommentAnchor
+	for rows.Next() {
+		var anchor models.CommentAnchor
+		if err := rows.Scan(&anchor.ID, &anchor.Start, &anchor.End); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		anchors = append(anchors, anchor)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	moved := []models.CommentAnchor{}
+	for _, anchor := range anchors {
+		start, end := textops.TransformRange(anchor.Start, anchor.End, change)
+		if start == anchor.Start && end == anchor.End {
+			continue
+		}
+		if _, err := q.Exec(
+			"UPDATE comments SET start_pos = $1, end_pos = $2 WHERE id = $3",
+			start, end, anchor.ID.String(),
+		); err != nil {
+			return nil, err
+		}
+		moved = append(moved, models.CommentAnchor{ID: anchor.ID, Start: start, End: end})
+	}
+	return moved, nil
+}
+
+// shiftedAnchors is what moving a document's anchors across a change
+// changed, kept to tell clients once the change commits.
+type shiftedAnchors struct {
+	locks       []models.Lock // nil unless a lock moved
+	comments    []models.CommentAnchor
+	suggestions []models.Suggestion
+}
+
+// shiftAnchors moves everything anchored to offsets in a document, such as
+// locks, comments and pending suggestions, across a normalized change that
+// produced revision. It runs in the transaction committing the change, while
+// the document row is locked, so anchors move once per change and in order.
+func (h *Handler) shiftAnchors(q queryer, documentID uuid.UUID, change models.TextChange, revision int64) (shiftedAnchors, error) {
+	var shifted shiftedAnchors
+	var err error
+	if shifted.locks, err = h.shiftLocks(q, documentID, change); err != nil {
+		return shifted, err
+	}
+	if shifted.comments, err = h.shiftComments(q, documentID, change); err != nil {
+		return shifted, err
+	}
+	shifted.suggestions, err = h.rebaseSuggestions(q, documentID, change, revision)
+	return shifted, err
+}
+
+// broadcastAnchors tells a document's clients about the anchors a committed
+// change moved.
+func (h *Handler) broadcastAnchors(documentID uuid.UUID, revision int64, shifted shiftedAnchors) {
+	if shifted.locks != nil {
+		h.broadcastLocks(documentID, shifted.locks)
+	}
+	if len(shifted.comments) > 0 {
+		h.broadcastComment("comment_anchors", documentID, models.CommentAnchors{DocumentID: documentID, Anchors: shifted.comments})
+	}
+	if len(shifted.suggestions) > 0 {
+		h.broadcastSuggestion("suggestions_rebased", documentID, models.SuggestionsRebased{
+			DocumentID:  documentID,
+			Revision:    revision,
+			Suggestions: shifted.suggestions,
+		})
+	}
+}
+
+// broadcastComment sends a comment_* event to the clients of a document.
+func (h *Handler) broadcastComment(eventType string, documentID uuid.UUID, data any) {
+	message := models.WebSocketMessage{Type: eventType, Data: data}
+	if encoded, err := json.Marshal(message); err == nil {
+		h.hub.BroadcastToDocument(documentID, encoded)
+	}
+}
+
+func (h *Handler) getComments(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
+
+	query := "SELECT " + commentColumns + " FROM comments WHERE document_id = $1"
+	if c.Query("resolved") == "false" {
+		query += " AND NOT resolved"
+	}
+	rows, err := h.db.Query(query+" ORDER BY start_pos, created_at", documentID.String())
+	if err != nil {
+		h.logger(c).Error("failed to query comments", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comments"})
+		return
+	}
+	defer rows.Close()
+
+	comments := []models.Comment{}
+	for rows.Next() {
+		comment, err := scanComment(rows)
+		if err != nil {
+			h.logger(c).Error("failed to scan comment", "document_id", documentID, "error", err)
+			continue
+		}
+		comments = append(comments, comment)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"document_id": documentID, "comments": comments})
+}
+
+func (h *Handler) createComment(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	var req struct {
+		UserName string `json:"user_name"`
+		Start    *int   `json:"start"`
+		End      *int   `json:"end"`
+		Body     string `json:"body"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	userID, ok := h.requireUser(c)
+	if !ok {
+		return
+	}
+	if _, ok := h.authorize(c, documentID, userID, auth.RoleCommenter); !ok {
+		return
+	}
+
+	req.Body = strings.TrimSpace(req.Body)
+	req.UserName = strings.TrimSpace(req.UserName)
+	if req.UserName == "" {
+		req.UserName = "Anonymous"
+	}
+	if req.Start == nil || req.End == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start and end are required"})
+		return
+	}
+	if req.Body == "" || len(req.Body) > maxCommentLength {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Comments must be between 1 and %d characters", maxCommentLength),
+		})
+		return
+	}
+
+	var contentLen int
+	err = h.db.QueryRow("SELECT OCTET_LENGTH(content) FROM documents WHERE id = $1", documentID.String()).Scan(&contentLen)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to get document length", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		return
+	}
+	if *req.Start < 0 || *req.End < *req.Start || *req.End > contentLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Comment must be anchored inside the document"})
+		return
+	}
+
+	comment, err := scanComment(h.db.QueryRow(
+		`INSERT INTO comments (document_id, start_pos, end_pos, author_id, author_name, body)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING `+commentColumns,
+		documentID.String(), *req.Start, *req.End, userID.String(), req.UserName, req.Body,
+	))
+	if err != nil {
+		logger.Error("failed to create comment", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create comment"})
+		return
+	}
+
+	logger.Info("comment created", "document_id", documentID, "comment_id", comment.ID, "user_id", userID)
+	h.broadcastComment("comment_created", documentID, comment)
+	c.JSON(http.StatusCreated, comment)
+}
+
+// commentForChange loads a comment and checks the caller may change it:
+// authors may always, and others need at least min. It writes the error
+// response and returns false on failure.
+func (h *Handler) commentForChange(c *gin.Context, min auth.Role) (models.Comment, bool) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return models.Comment{}, false
+	}
+	commentID, err := uuid.Parse(c.Param("commentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid comment ID"})
+		return models.Comment{}, false
+	}
+
+	comment, err := scanComment(h.db.QueryRow(
+		"SELECT "+commentColumns+" FROM comments WHERE id = $1 AND document_id = $2",
+		commentID.String(), documentID.String(),
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Comment not found"})
+		return comment, false
+	} else if err != nil {
+		h.logger(c).Error("failed to get comment", "comment_id", commentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get comment"})
+		return comment, false
+	}
+
+	userID := requestUser(c)
+	if userID != uuid.Nil && userID == comment.AuthorID {
+		_, ok := h.authorize(c, documentID, userID, auth.RoleCommenter)
+		return comment, ok
+	}
+	_, ok := h.authorize(c, documentID, userID, min)
+	return comment, ok
+}
+
+func (h *Handler) updateComment(c *gin.Context) {
+	var req struct {
+		Body     *string `json:"body"`
+		Resolved *bool   `json:"resolved"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+
+	// Editors may resolve anyone's comment, but only owners may reword one
+	min := auth.RoleEditor
+	if req.Body != nil {
+		min = auth.RoleOwner
+	}
+	comment, ok := h.commentForChange(c, min)
+	if !ok {
+		return
+	}
+
+	if req.Body != nil {
+		body := strings.TrimSpace(*req.Body)
+		if body == "" || len(body) > maxCommentLength {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": fmt.Sprintf("Comments must be between 1 and %d characters", maxCommentLength),
+			})
+			return
+		}
+		comment.Body = body
+	}
+	if req.Resolved != nil {
+		comment.Resolved = *req.Resolved
+	}
+
+	err := h.db.QueryRow(
+		"UPDATE comments SET body = $1, resolved = $2, updated_at = now() WHERE id = $3 RETURNING updated_at",
+		comment.Body, comment.Resolved, comment.ID.String(),
+	).Scan(&comment.UpdatedAt)
+	if err != nil {
+		h.logger(c).Error("failed to update comment", "comment_id", comment.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update comment"})
+		return
+	}
+
+	h.logger(c).Info("comment updated", "document_id", comment.DocumentID, "comment_id", comment.ID, "resolved", comment.Resolved)
+	h.broadcastComment("comment_updated", comment.DocumentID, comment)
+	c.JSON(http.StatusOK, comment)
+}
+
+func (h *Handler) deleteComment(c *gin.Context) {
+	comment, ok := h.commentForChange(c, auth.RoleOwner)
+	if !ok {
+		return
+	}
+
+	if _, err := h.db.Exec("DELETE FROM comments WHERE id = $1", comment.ID.String()); err != nil {
+		h.logger(c).Error("failed to delete comment", "comment_id", comment.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete comment"})
+		return
+	}
+
+	h.logger(c).Info("comment deleted", "document_id", comment.DocumentID, "comment_id", comment.ID)
+	h.broadcastComment("comment_deleted", comment.DocumentID, models.CommentDeleted{DocumentID: comment.DocumentID, ID: comment.ID})
+	c.Status(http.StatusNoContent)
+}
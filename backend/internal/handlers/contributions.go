@@ -0,0 +1,258 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Leaderboard sizes, by default and at most.
+const (
+	defaultLeaderboardSize = 20
+	maxLeaderboardSize     = 100
+)
+
+// leaderboardOrders are the ways a leaderboard can be sorted.
+var leaderboardOrders = map[string]string{
+	"edits":         "inserts + deletes + replaces",
+	"chars_added":   "chars_added",
+	"chars_removed": "chars_removed",
+	"recent":        "last_at",
+}
+
+const contributionColumns = "document_id, user_id, user_name, inserts, deletes, replaces, chars_added, chars_removed, reverted, first_at, last_at"
+
+func scanContribution(row interface{ Scan(...any) error }) (models.DocumentContribution, error) {
+	var stats models.DocumentContribution
+	var first, last time.Time
+	err := row.Scan(
+		&stats.DocumentID, &stats.UserID, &stats.UserName, &stats.Inserts, &stats.Deletes, &stats.Replaces,
+		&stats.CharsAdded, &stats.CharsRemoved, &stats.Reverted, &first, &last,
+	)
+	stats.Edits = stats.Inserts + stats.Deletes + stats.Replaces
+	stats.FirstContribution, stats.LastContribution = &first, &last
+	return stats, err
+}
+
+// recordContribution adds a committed, normalized change, which removed
+// the text removedText, to its author's totals. Text is counted in
+// characters, as the content policy counts it. System changes, such as
+// reverts, are not counted.
+func (h *Handler) recordContribution(logger *slog.Logger, documentID uuid.UUID, change models.TextChange, removedText string, at time.Time) {
+	if change.UserID == uuid.Nil {
+		return
+	}
+	var inserts, deletes, replaces int
+	added, removed := utf8.RuneCountInString(change.Content), utf8.RuneCountInString(removedText)
+	switch change.ChangeType {
+	case "insert":
+		inserts, removed = 1, 0
+	case "delete":
+		deletes, added = 1, 0
+	case "replace":
+		replaces = 1
+	}
+
+	_, err := h.db.Exec(
+		`INSERT INTO contribution_stats AS s (document_id, user_id, user_name, inserts, deletes, replaces, chars_added, chars_removed, first_at, last_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		ON CONFLICT (document_id, user_id) DO UPDATE SET
+			user_name = EXCLUDED.user_name,
+			inserts = s.inserts + EXCLUDED.inserts,
+			deletes = s.deletes + EXCLUDED.deletes,
+			replaces = s.replaces + EXCLUDED.replaces,
+			chars_added = s.chars_added + EXCLUDED.chars_added,
+			chars_removed = s.chars_removed + EXCLUDED.chars_removed,
+			last_at = GREATEST(s.last_at, EXCLUDED.last_at)`,
+		documentID.String(), change.UserID.String(), change.UserName, inserts, deletes, replaces, added, removed, at,
+	)
+	if err != nil {
+		logger.Error("failed to record contribution", "user_id", change.UserID, "error", err)
+		return
+	}
+	if _, err := h.db.Exec(
+		"INSERT INTO contribution_days (user_id, document_id, day) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+		change.UserID.String(), documentID.String(), at.UTC().Format(time.DateOnly),
+	); err != nil {
+		logger.Error("failed to record contribution day", "user_id", change.UserID, "error", err)
+	}
+}
+
+// recordRevert counts a reverted change against its author.
+func (h *Handler) recordRevert(logger *slog.Logger, documentID, userID uuid.UUID) {
+	if userID == uuid.Nil {
+		return
+	}
+	if _, err := h.db.Exec(
+		"UPDATE contribution_stats SET reverted = reverted + 1 WHERE document_id = $1 AND user_id = $2",
+		documentID.String(), userID.String(),
+	); err != nil {
+		logger.Error("failed to record revert", "user_id", userID, "error", err)
+	}
+}
+
+func (h *Handler) getLeaderboard(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
+	sortBy := c.DefaultQuery("sort", "edits")
+	order, ok := leaderboardOrders[sortBy]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sort must be edits, chars_added, chars_removed or recent"})
+		return
+	}
+	limit := defaultLeaderboardSize
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxLeaderboardSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxLeaderboardSize)})
+			return
+		}
+		limit = n
+	}
+
+	rows, err := h.db.Query(
+		"SELECT "+contributionColumns+" FROM contribution_stats WHERE document_id = $1 ORDER BY "+order+" DESC, first_at",
+		documentID.String(),
+	)
+	if err != nil {
+		h.logger(c).Error("failed to query leaderboard", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get leaderboard"})
+		return
+	}
+	defer rows.Close()
+
+	// Every contributor counts towards the totals; only the top ones are listed
+	var totals models.ContributionCounts
+	contributors := 0
+	entries := []models.LeaderboardEntry{}
+	for rows.Next() {
+		stats, err := scanContribution(rows)
+		if err != nil {
+			h.logger(c).Error("failed to scan contribution", "document_id", documentID, "error", err)
+			continue
+		}
+		contributors++
+		totals.Add(stats.ContributionCounts)
+		if len(entries) < limit {
+			entries = append(entries, models.LeaderboardEntry{Rank: len(entries) + 1, DocumentContribution: stats})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"document_id":  documentID,
+		"sort":         sortBy,
+		"contributors": contributors,
+		"totals":       totals,
+		"leaderboard":  entries,
+	})
+}
+
+func (h *Handler) getUserStats(c *gin.Context) {
+	logger := h.logger(c)
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	// Only documents the caller may view are listed and counted
+	args := []any{userID.String()}
+	rows, err := h.db.Query(
+		`SELECT s.`+strings.ReplaceAll(contributionColumns, ", ", ", s.")+` FROM contribution_stats s JOIN documents d ON d.id = s.document_id
+		WHERE s.user_id = $1`+h.viewableFilter(c, &args)+` ORDER BY s.last_at DESC`,
+		args...,
+	)
+	if err != nil {
+		logger.Error("failed to query user stats", "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user stats"})
+		return
+	}
+	defer rows.Close()
+
+	stats := models.UserStats{UserID: userID, Documents: []models.DocumentContribution{}}
+	for rows.Next() {
+		contribution, err := scanContribution(rows)
+		if err != nil {
+			logger.Error("failed to scan contribution", "user_id", userID, "error", err)
+			continue
+		}
+		if stats.UserName == "" {
+			stats.UserName = contribution.UserName
+		}
+		stats.Add(contribution.ContributionCounts)
+		stats.Documents = append(stats.Documents, contribution)
+	}
+	if err := rows.Err(); err != nil {
+		logger.Error("contribution row iteration failed", "user_id", userID, "error", err)
+	}
+
+	dayArgs := []any{userID.String()}
+	days, err := h.db.Query(
+		`SELECT DISTINCT day FROM contribution_days cd JOIN documents d ON d.id = cd.document_id
+		WHERE cd.user_id = $1`+h.viewableFilter(c, &dayArgs)+` ORDER BY day`,
+		dayArgs...,
+	)
+	if err != nil {
+		logger.Error("failed to query contribution days", "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user stats"})
+		return
+	}
+	defer days.Close()
+	var active []time.Time
+	for days.Next() {
+		var day time.Time
+		if err := days.Scan(&day); err != nil {
+			logger.Error("failed to scan contribution day", "user_id", userID, "error", err)
+			continue
+		}
+		active = append(active, day)
+	}
+	stats.ActiveDays = len(active)
+	stats.CurrentStreak, stats.LongestStreak = streaks(active, time.Now().UTC())
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// streaks measures runs of consecutive days in sorted, distinct days. The
+// current streak counts back from today, or from yesterday if today has no
+// contribution yet.
+func streaks(days []time.Time, now time.Time) (current, longest int) {
+	run := 0
+	for i, day := range days {
+		if i > 0 && sameDay(days[i-1].AddDate(0, 0, 1), day) {
+			run++
+		} else {
+			run = 1
+		}
+		longest = max(longest, run)
+	}
+	if n := len(days); n > 0 {
+		last := days[n-1]
+		if sameDay(last, now) || sameDay(last.AddDate(0, 0, 1), now) {
+			current = run
+		}
+	}
+	return current, longest
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+)
+
+func day(s string) time.Time {
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+func days(s ...string) []time.Time {
+	out := make([]time.Time, len(s))
+	for i := range s {
+		out[i] = day(s[i])
+	}
+	return out
+}
+
+func TestStreaks(t *testing.T) {
+	now := day("2026-03-10").Add(15 * time.Hour)
+	tests := []struct {
+		name             string
+		days             []time.Time
+		current, longest int
+	}{
+		{"no contributions", nil, 0, 0},
+		{"only today", days("2026-03-10"), 1, 1},
+		{"only yesterday", days("2026-03-09"), 1, 1},
+		{"two days ago", days("2026-03-08"), 0, 1},
+		{"run ending today", days("2026-03-08", "2026-03-09", "2026-03-10"), 3, 3},
+		{"run ending yesterday", days("2026-03-07", "2026-03-08", "2026-03-09"), 3, 3},
+		{"gap breaks the run", days("2026-03-06", "2026-03-07", "2026-03-09", "2026-03-10"), 2, 2},
+		{"longest run in the past", days("2026-01-01", "2026-01-02", "2026-01-03", "2026-03-10"), 1, 3},
+		{"broken run keeps its length", days("2026-03-01", "2026-03-02", "2026-03-03", "2026-03-04"), 0, 4},
+		{"across a month", days("2026-02-27", "2026-02-28", "2026-03-01"), 0, 3},
+		{"across a year", days("2025-12-30", "2025-12-31", "2026-01-01"), 0, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, longest := streaks(tt.days, now)
+			if current != tt.current || longest != tt.longest {
+				t.Errorf("streaks = (%d, %d), want (%d, %d)", current, longest, tt.current, tt.longest)
+			}
+		})
+	}
+}
+
+func TestStreaksLeapDay(t *testing.T) {
+	leap := days("2028-02-28", "2028-02-29", "2028-03-01")
+	if current, longest := streaks(leap, day("2028-03-02")); current != 3 || longest != 3 {
+		t.Errorf("streaks over a leap day = (%d, %d), want (3, 3)", current, longest)
+	}
+	// Without a leap day the 28th and the 1st are consecutive
+	plain := days("2027-02-28", "2027-03-01")
+	if current, longest := streaks(plain, day("2027-03-01")); current != 2 || longest != 2 {
+		t.Errorf("streaks over the end of February = (%d, %d), want (2, 2)", current, longest)
+	}
+}
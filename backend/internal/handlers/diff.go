@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/diff"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/textops"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// diffContext is how many unchanged lines or words surround each hunk.
+const diffContext = 3
+
+// errInvalidPoint marks diff endpoints that name no revision.
+var errInvalidPoint = errors.New("not a revision, change ID or time in this document")
+
+// resolveRevision turns a diff endpoint into a revision. It may be a
+// revision number, the ID of a change (the revision it produced) or a
+// timestamp (the last revision committed by then).
+func (h *Handler) resolveRevision(documentID uuid.UUID, point string, current int64) (int64, error) {
+	if n, err := strconv.ParseInt(point, 10, 64); err == nil {
+		if n < 0 || n > current {
+			return 0, errInvalidPoint
+		}
+		return n, nil
+	}
+	if changeID, err := uuid.Parse(point); err == nil {
+		var revision sql.NullInt64
+		err := h.db.QueryRow(
+			"SELECT revision FROM changes WHERE id = $1 AND document_id = $2",
+			changeID.String(), documentID.String(),
+		).Scan(&revision)
+		if err == sql.ErrNoRows || (err == nil && !revision.Valid) {
+			return 0, errInvalidPoint
+		}
+		return revision.Int64, err
+	}
+	at, err := parseSearchTime(point, false)
+	if err != nil {
+		return 0, errInvalidPoint
+	}
+	var revision int64
+	err = h.db.QueryRow(
+		"SELECT COALESCE(MAX(revision), 0) FROM changes WHERE document_id = $1 AND timestamp <= $2",
+		documentID.String(), at,
+	).Scan(&revision)
+	return revision, err
+}
+
+// contentAt reconstructs a document at an earlier revision by undoing the
+// changes after it, newest first, starting from the current content.
+func (h *Handler) contentAt(documentID uuid.UUID, content string, current, revision int64) (string, error) {
+	if revision >= current {
+		return content, nil
+	}
+	rows, err := h.db.Query(
+		`SELECT change_type, content, position, length, removed FROM changes
+		WHERE document_id = $1 AND revision > $2 AND revision <= $3 ORDER BY revision DESC`,
+		documentID.String(), revision, current,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change models.TextChange
+		var removed string
+		if err := rows.Scan(&change.ChangeType, &change.Content, &change.Position, &change.Length, &removed); err != nil {
+			return "", err
+		}
+		inverse := textops.Normalize(textops.Invert(change, removed), len(content))
+		content = textops.Apply(content, inverse)
+	}
+	return content, rows.Err()
+}
+
+func (h *Handler) getDiff(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
+	byWord := false
+	switch c.DefaultQuery("granularity", "line") {
+	case "line":
+	case "word":
+		byWord = true
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Granularity must be line or word"})
+		return
+	}
+	if c.Query("from") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from is required"})
+		return
+	}
+
+	var content string
+	var current int64
+	err = h.db.QueryRow(
+		"SELECT COALESCE(content, ''), revision FROM documents WHERE id = $1",
+		documentID.String(),
+	).Scan(&content, &current)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to get document", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		return
+	}
+
+	points := map[string]int64{"to": current}
+	for _, name := range []string{"from", "to"} {
+		point := c.Query(name)
+		if point == "" {
+			continue
+		}
+		revision, err := h.resolveRevision(documentID, point, current)
+		if errors.Is(err, errInvalidPoint) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is %v", name, err)})
+			return
+		} else if err != nil {
+			logger.Error("failed to resolve revision", "document_id", documentID, "point", point, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve revision"})
+			return
+		}
+		points[name] = revision
+	}
+
+	texts := map[string]string{}
+	for name, revision := range points {
+		text, err := h.contentAt(documentID, content, current, revision)
+		if err != nil {
+			logger.Error("failed to reconstruct document", "document_id", documentID, "revision", revision, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconstruct document"})
+			return
+		}
+		texts[name] = text
+	}
+
+	from, to, byWord, err := diff.Tokens(texts["from"], texts["to"], byWord)
+	if err == diff.ErrTooLarge {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error": fmt.Sprintf("Versions over %d lines are too large to diff", diff.MaxTokens),
+			"code":  "diff_too_large",
+		})
+		return
+	}
+	granularity := "line"
+	if byWord {
+		granularity = "word"
+	}
+	script := diff.Compute(from, to)
+	hunks := diff.Hunks(script, diffContext)
+	if hunks == nil {
+		hunks = []diff.Hunk{}
+	}
+	insertions, deletions := 0, 0
+	for _, line := range script {
+		switch line.Op {
+		case diff.OpInsert:
+			insertions++
+		case diff.OpDelete:
+			deletions++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"document_id": documentID,
+		"from":        points["from"],
+		"to":          points["to"],
+		"granularity": granularity,
+		"insertions":  insertions,
+		"deletions":   deletions,
+		"hunks":       hunks,
+		"unified": diff.Unified(
+			fmt.Sprintf("revision %d", points["from"]),
+			fmt.Sprintf("revision %d", points["to"]),
+			hunks, byWord,
+		),
+	})
+}
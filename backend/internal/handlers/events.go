@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/websocket"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// eventKeepAlive is how often an idle event stream gets a comment so
+// proxies keep it open.
+const eventKeepAlive = 25 * time.Second
+
+// maxEventReplay bounds how many missed changes a resumed stream replays;
+// further behind, the client is told to resync instead.
+const maxEventReplay = 1000
+
+// replayedChange is a committed change as its text_change message.
+type replayedChange struct {
+	revision int64
+	message  models.WebSocketMessage
+}
+
+// StopStreams ends every event stream with a going_away event. It is
+// called when the server starts shutting down.
+func (h *Handler) StopStreams() {
+	h.stopStreams.Do(func() { close(h.streamsStopping) })
+}
+
+// writeEvent writes one Server-Sent Event. An empty id leaves the client's
+// last event ID as it was.
+func writeEvent(w io.Writer, event, id string, data []byte) error {
+	var buf bytes.Buffer
+	if id != "" {
+		fmt.Fprintf(&buf, "id: %s\n", id)
+	}
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteString("\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// replayChanges returns a document's changes after a revision, oldest
+// first, and whether there were more than limit.
+func (h *Handler) replayChanges(documentID uuid.UUID, after int64, limit int) ([]replayedChange, bool, error) {
+	rows, err := h.db.Query(
+		`SELECT c.id, c.user_id, c.user_name, c.change_type, c.content, c.position, c.length, c.revision, t.id
+		FROM changes c LEFT JOIN changes t ON t.document_id = c.document_id AND t.reverted_by = c.id
+		WHERE c.document_id = $1 AND c.revision > $2 ORDER BY c.revision LIMIT $3`,
+		documentID.String(), after, limit+1,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	var changes []replayedChange
+	for rows.Next() {
+		var changeID, userID uuid.UUID
+		var userName, changeType, content string
+		var position, length int
+		var revision int64
+		var reverts uuid.NullUUID
+		if err := rows.Scan(&changeID, &userID, &userName, &changeType, &content, &position, &length, &revision, &reverts); err != nil {
+			return nil, false, err
+		}
+		data := map[string]interface{}{
+			"changeID":   changeID.String(),
+			"documentId": documentID.String(),
+			"userID":     userID.String(),
+			"userName":   userName,
+			"changeType": changeType,
+			"content":    content,
+			"position":   position,
+			"length":     length,
+			"revision":   revision,
+		}
+		if reverts.Valid {
+			data["reverts"] = reverts.UUID.String()
+		}
+		changes = append(changes, replayedChange{revision, models.WebSocketMessage{Type: "text_change", Data: data}})
+	}
+	if len(changes) > limit {
+		return changes[:limit], true, rows.Err()
+	}
+	return changes, false, rows.Err()
+}
+
+// streamEvents sends a document's hub broadcasts as Server-Sent Events, for
+// readers that cannot use websockets. Each event is named after the message
+// type and carries the same JSON as the websocket message. Committed changes
+// use their revision as event ID, so a client reconnecting with
+// Last-Event-ID first gets the changes it missed.
+func (h *Handler) streamEvents(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	userID := requestUser(c)
+	if _, ok := h.authorize(c, documentID, userID, auth.RoleViewer); !ok {
+		return
+	}
+	resumeFrom := int64(-1)
+	if value := c.GetHeader("Last-Event-ID"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Last-Event-ID must be a revision"})
+			return
+		}
+		resumeFrom = n
+	}
+
+	// Subscribe before reading history so no change falls in between
+	client, ok := h.hub.Watch(c, documentID, userID)
+	if !ok {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Server is shutting down"})
+		return
+	}
+	defer h.hub.Leave(client)
+
+	var revision int64
+	if err := h.db.QueryRow(
+		"SELECT COALESCE((SELECT revision FROM documents WHERE id = $1), 0)", documentID.String(),
+	).Scan(&revision); err != nil {
+		logger.Error("failed to get document revision", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		return
+	}
+	var replay []replayedChange
+	resync := false
+	if resumeFrom >= 0 && resumeFrom < revision {
+		replay, resync, err = h.replayChanges(documentID, resumeFrom, maxEventReplay)
+		if err != nil {
+			logger.Error("failed to replay changes", "document_id", documentID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay changes"})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	control := http.NewResponseController(c.Writer)
+	write := func(event, id string, data []byte) bool {
+		control.SetWriteDeadline(time.Now().Add(h.cfg.WebSocket.WriteWait.Duration))
+		if err := writeEvent(c.Writer, event, id, data); err != nil {
+			return false
+		}
+		return control.Flush() == nil
+	}
+	send := func(id int64, message models.WebSocketMessage) bool {
+		data, err := json.Marshal(message)
+		return err == nil && write(message.Type, strconv.FormatInt(id, 10), data)
+	}
+	if control.Flush() != nil {
+		return
+	}
+
+	// Live copies of changes the client already has are skipped
+	sent := int64(-1)
+	switch {
+	case resync:
+		// Too far behind to replay; the client should reload the document
+		sent = revision
+		replay = nil
+		if !send(revision, models.WebSocketMessage{
+			Type: "resync",
+			Data: gin.H{"document_id": documentID, "revision": revision},
+		}) {
+			return
+		}
+	case resumeFrom >= 0:
+		sent = min(resumeFrom, revision)
+	default:
+		if !send(revision, models.WebSocketMessage{
+			Type: "connected",
+			Data: gin.H{"document_id": documentID, "revision": revision},
+		}) {
+			return
+		}
+	}
+	for _, change := range replay {
+		if !send(change.revision, change.message) {
+			return
+		}
+		sent = change.revision
+	}
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-h.streamsStopping:
+			delay := websocket.ReconnectDelay()
+			data, _ := json.Marshal(models.WebSocketMessage{
+				Type: "going_away",
+				Data: models.GoingAway{Reason: "server_shutdown", ReconnectAfterMS: delay.Milliseconds()},
+			})
+			// retry makes EventSource wait as long before reconnecting
+			fmt.Fprintf(c.Writer, "retry: %d\n", delay.Milliseconds())
+			write("going_away", "", data)
+			return
+		case <-keepAlive.C:
+			control.SetWriteDeadline(time.Now().Add(h.cfg.WebSocket.WriteWait.Duration))
+			if _, err := io.WriteString(c.Writer, ": keep-alive\n\n"); err != nil || control.Flush() != nil {
+				return
+			}
+		case message, ok := <-client.Send:
+			if !ok {
+				return
+			}
+			if event, id, keep := streamedEvent(documentID, message, sent); keep && !write(event, id, message) {
+				return
+			}
+		}
+	}
+}
+
+// streamedEvent names a hub message for an event stream and picks its ID.
+// Only messages naming the stream's document are kept, apart from
+// going_away, which is about the connection; changes at or before revision
+// sent are left out too.
+func streamedEvent(documentID uuid.UUID, message []byte, sent int64) (string, string, bool) {
+	var envelope struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(message, &envelope); err != nil || envelope.Type == "" {
+		return "", "", false
+	}
+	if envelope.Type == "going_away" {
+		return envelope.Type, "", true
+	}
+	// Most messages name their document as document_id; text_change uses documentId
+	var data struct {
+		DocumentID string `json:"document_id"`
+		DocumentId string `json:"documentId"`
+		Revision   *int64 `json:"revision"`
+	}
+	json.Unmarshal(envelope.Data, &data)
+	if data.DocumentID != documentID.String() && data.DocumentId != documentID.String() {
+		return "", "", false
+	}
+	if envelope.Type != "text_change" || data.Revision == nil {
+		return envelope.Type, "", true
+	}
+	if *data.Revision <= sent {
+		return "", "", false
+	}
+	return envelope.Type, strconv.FormatInt(*data.Revision, 10), true
+}
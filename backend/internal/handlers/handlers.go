@@ -1,41 +1,185 @@
 package handlers
 
 import (
-    "bytes"
-    "database/sql"
-    "encoding/json"
-    "fmt"
-    "io"
-    "log"
-    "net/http"
-    "regexp"
-    "strings"
-    "time"
-    "os"
-
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/blame"
+	"storychain-backend/internal/config"
+	"storychain-backend/internal/logging"
+	"storychain-backend/internal/metrics"
 	"storychain-backend/internal/models"
+	"storychain-backend/internal/moderation"
+	"storychain-backend/internal/policy"
+	"storychain-backend/internal/ratelimit"
+	"storychain-backend/internal/textops"
+	"storychain-backend/internal/webhooks"
 	"storychain-backend/internal/websocket"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
-type Handler struct {
-	db  *sql.DB
-	hub *websocket.Hub
+// statsInterval is how often connected clients may get a stats_update.
+const statsInterval = 10 * time.Second
+
+// queryer is what *sql.DB and *sql.Tx have in common, so helpers can read
+// inside a transaction or outside one.
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
 }
 
-func SetupRoutes(r *gin.RouterGroup, db *sql.DB, hub *websocket.Hub) {
-	h := &Handler{db: db, hub: hub}
+type Handler struct {
+	cfg       *config.Config
+	db        *sql.DB
+	hub       *websocket.Hub
+	moderator *moderation.Client
+	limiter   *ratelimit.Limiter
+	invites   *auth.Signer
+	sessions  *auth.Signer
+	blame     *blame.Cache
+	webhooks  *webhooks.Dispatcher
+	log       *slog.Logger
+
+	// instanceID tells this process's activity samples from other instances'
+	instanceID uuid.UUID
+
+	// background tracks work that outlives its request, such as moderation,
+	// so shutdown can wait for it; backgroundCtx is cancelled if it cannot.
+	background       sync.WaitGroup
+	backgroundCtx    context.Context
+	cancelBackground context.CancelFunc
+
+	// streamsStopping is closed to end event streams when the server shuts down
+	streamsStopping chan struct{}
+	stopStreams     sync.Once
+}
 
-	r.GET("/ws", func(c *gin.Context) {
-		websocket.HandleWebSocket(c, hub)
+func SetupRoutes(r *gin.RouterGroup, cfg *config.Config, db *sql.DB, hub *websocket.Hub, moderator *moderation.Client, limiter *ratelimit.Limiter, logger *slog.Logger) *Handler {
+	h := &Handler{cfg: cfg, db: db, hub: hub, moderator: moderator, limiter: limiter, blame: blame.NewCache(), log: logger, instanceID: uuid.New()}
+	h.invites = auth.NewSigner(cfg.Server.InviteSecret)
+	if cfg.Server.InviteSecret == "" {
+		logger.Warn("INVITE_SECRET is not set; invite links will stop working on restart")
+	}
+	h.sessions = auth.NewSigner(cfg.Server.SessionSecret)
+	if cfg.Server.SessionSecret == "" {
+		logger.Warn("SESSION_SECRET is not set; sessions will stop working on restart")
+	}
+	h.backgroundCtx, h.cancelBackground = context.WithCancel(context.Background())
+	h.streamsStopping = make(chan struct{})
+	h.loadChains()
+	h.webhooks = webhooks.NewDispatcher(db, cfg.Webhooks, logger)
+	hub.OnJoin(func(documentID, userID uuid.UUID, userName string) {
+		h.emit(logger, documentID, webhooks.UserJoined, gin.H{"user_id": userID, "user_name": userName})
 	})
 
-	r.GET("/document/:id", h.getDocument)
-	r.PUT("/document/:id", h.updateDocument)
-	r.GET("/changes/:documentId", h.getChanges)
-	r.GET("/stats", h.getStats)
+	// API keys may read, and edit documents with the write scope, but not
+	// use the other write routes
+	read := h.identify(auth.ScopeRead, limiter.Middleware("read"))
+	write := h.identify("", limiter.Middleware("write"))
+	edit := h.identify(auth.ScopeWrite, limiter.Middleware("write"))
+
+	r.GET("/ws", h.identify("", limiter.Middleware("connect")), h.connect)
+	r.POST("/session", write, h.createSession)
+
+	r.GET("/document/:id", read, h.getDocument)
+	r.PUT("/document/:id", edit, h.updateDocument)
+	r.GET("/document/:id/presence", read, h.getPresence)
+	r.GET("/document/:id/events", h.identify(auth.ScopeRead, limiter.Middleware("connect")), h.streamEvents)
+	r.GET("/document/:id/blame", read, h.getBlame)
+	r.GET("/document/:id/diff", read, h.getDiff)
+	r.PUT("/document/:id/chain", write, h.updateChain)
+	r.GET("/document/:id/turn", read, h.getTurn)
+	r.GET("/document/:id/locks", read, h.getLocks)
+	r.POST("/document/:id/locks", write, h.createLock)
+	r.DELETE("/document/:id/locks/:lockId", write, h.deleteLock)
+	r.GET("/document/:id/roles", read, h.getRoles)
+	r.PUT("/document/:id/roles/:userId", write, h.setRole)
+	r.DELETE("/document/:id/roles/:userId", write, h.deleteRole)
+	r.PUT("/document/:id/access", write, h.updateAccess)
+	r.GET("/document/:id/comments", read, h.getComments)
+	r.POST("/document/:id/comments", write, h.createComment)
+	r.PATCH("/document/:id/comments/:commentId", write, h.updateComment)
+	r.DELETE("/document/:id/comments/:commentId", write, h.deleteComment)
+	r.POST("/document/:id/changes/:changeId/vote", write, h.voteChange)
+	r.GET("/document/:id/suggestions", read, h.getSuggestions)
+	r.POST("/document/:id/suggestions", write, h.createSuggestion)
+	r.POST("/document/:id/suggestions/:suggestionId/accept", write, h.acceptSuggestion)
+	r.POST("/document/:id/suggestions/:suggestionId/reject", write, h.rejectSuggestion)
+	r.GET("/document/:id/invites", read, h.getInvites)
+	r.POST("/document/:id/invites", write, h.createInvite)
+	r.DELETE("/document/:id/invites/:inviteId", write, h.revokeInvite)
+	r.GET("/document/:id/webhooks", read, h.getWebhooks)
+	r.POST("/document/:id/webhooks", write, h.createWebhook)
+	r.DELETE("/document/:id/webhooks/:webhookId", write, h.deleteWebhook)
+	r.GET("/document/:id/webhooks/:webhookId/deliveries", read, h.getWebhookDeliveries)
+	r.GET("/changes/:documentId", read, h.getChanges)
+	r.GET("/document/:id/leaderboard", read, h.getLeaderboard)
+	r.GET("/users/:id/stats", read, h.getUserStats)
+	r.GET("/search", read, h.search)
+	r.GET("/stats", read, h.getStats)
+	r.GET("/stats/timeseries", read, h.getTimeseries)
+	r.GET("/keys", read, h.getAPIKeys)
+	r.POST("/keys", write, h.createAPIKey)
+	r.DELETE("/keys/:keyId", write, h.revokeAPIKey)
+
+	return h
+}
+
+// DeliverWebhooks sends queued webhook deliveries until ctx is cancelled.
+func (h *Handler) DeliverWebhooks(ctx context.Context) {
+	h.webhooks.Run(ctx)
+}
+
+// Drain waits for background work started by requests to finish. If ctx
+// expires first, the remaining work is cancelled and ctx's error returned.
+func (h *Handler) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		h.cancelBackground()
+		return ctx.Err()
+	}
+}
+
+// connect upgrades to a websocket once the user may view the document.
+func (h *Handler) connect(c *gin.Context) {
+	// Live updates are scoped to a document, so a client must name one
+	documentID, err := uuid.Parse(c.Query("document_id"))
+	if err != nil || documentID == uuid.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	userID := requestUser(c)
+	role, ok := h.authorize(c, documentID, userID, auth.RoleViewer)
+	if !ok {
+		return
+	}
+	websocket.HandleWebSocket(c, h.hub, documentID, userID, !role.AtLeast(auth.RoleEditor))
+}
+
+// logger returns the request-scoped logger carrying the request ID.
+func (h *Handler) logger(c *gin.Context) *slog.Logger {
+	return logging.FromContext(c, h.log)
 }
 
 func (h *Handler) getDocument(c *gin.Context) {
@@ -45,6 +189,11 @@ func (h *Handler) getDocument(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
 		return
 	}
+	userID := requestUser(c)
+	role, ok := h.authorize(c, documentID, userID, auth.RoleViewer)
+	if !ok {
+		return
+	}
 
 	// Create a fallback document if database fails
 	fallbackDoc := models.Document{
@@ -52,14 +201,22 @@ func (h *Handler) getDocument(c *gin.Context) {
 		Content:   "# Welcome to StoryChain\n\nThis is a collaborative text editor where you can edit text in real-time with other users.\n\n## How it works\n- Click on any word to edit it\n- Click between words or at the end to add new text\n- You get a 10-second cooldown after each edit\n- Changes are saved automatically and synced with all users\n\n## Features\n- **Real-time collaboration**: See changes from other users instantly\n- **Markdown support**: Use markdown syntax for formatting\n- **Change history**: Track all edits in the sidebar\n- **User presence**: See who's online and editing\n\nStart editing by clicking on any word above!",
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
+		Chain:     defaultChain,
+		Locks:     []models.Lock{},
+		// Matches the column default
+		DefaultRole: string(auth.RoleEditor),
+		Role:        string(role),
 	}
 
 	// Try to get document from database
 	var doc models.Document
 	var idStr, contentStr, createdStr, updatedStr sql.NullString
-	
-	err = h.db.QueryRow("SELECT id::text, COALESCE(content, ''), created_at::text, updated_at::text FROM documents WHERE id = $1", documentID.String()).Scan(&idStr, &contentStr, &createdStr, &updatedStr)
-	
+
+	err = h.db.QueryRow(
+		"SELECT id::text, COALESCE(content, ''), created_at::text, updated_at::text, chain_mode, chain_unit, turn_seconds, default_role, revision FROM documents WHERE id = $1",
+		documentID.String(),
+	).Scan(&idStr, &contentStr, &createdStr, &updatedStr, &doc.Chain.Enabled, &doc.Chain.Unit, &doc.Chain.TurnSeconds, &doc.DefaultRole, &doc.Revision)
+
 	if err == sql.ErrNoRows {
 		// Document doesn't exist, create it
 		_, err = h.db.Exec(
@@ -67,13 +224,24 @@ func (h *Handler) getDocument(c *gin.Context) {
 			fallbackDoc.ID.String(), fallbackDoc.Content, fallbackDoc.CreatedAt, fallbackDoc.UpdatedAt,
 		)
 		if err != nil {
-			log.Printf("Failed to create document: %v", err)
+			h.logger(c).Error("failed to create document", "document_id", documentID, "error", err)
 			// Return fallback document even if insert fails
+		} else if userID != uuid.Nil {
+			// Whoever creates a document owns it
+			_, err = h.db.Exec(
+				"INSERT INTO document_roles (document_id, user_id, role) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING",
+				documentID.String(), userID.String(), string(auth.RoleOwner),
+			)
+			if err != nil {
+				h.logger(c).Error("failed to record document owner", "document_id", documentID, "error", err)
+			} else {
+				fallbackDoc.Role = string(auth.RoleOwner)
+			}
 		}
 		c.JSON(http.StatusOK, fallbackDoc)
 		return
 	} else if err != nil {
-		log.Printf("Failed to retrieve document: %v", err)
+		h.logger(c).Error("failed to retrieve document", "document_id", documentID, "error", err)
 		// Return fallback document if query fails
 		c.JSON(http.StatusOK, fallbackDoc)
 		return
@@ -81,238 +249,416 @@ func (h *Handler) getDocument(c *gin.Context) {
 
 	// Parse the retrieved data
 	doc.ID = documentID
+	doc.Role = string(role)
 	doc.Content = contentStr.String
 	if doc.Content == "" {
 		doc.Content = fallbackDoc.Content
 	}
 	doc.CreatedAt, _ = time.Parse(time.RFC3339, createdStr.String)
 	doc.UpdatedAt, _ = time.Parse(time.RFC3339, updatedStr.String)
+	doc.Locks, err = h.documentLocks(h.db, documentID)
+	if err != nil {
+		h.logger(c).Error("failed to retrieve document locks", "document_id", documentID, "error", err)
+		doc.Locks = []models.Lock{}
+	}
 
 	c.JSON(http.StatusOK, doc)
 }
 
 func (h *Handler) updateDocument(c *gin.Context) {
-	log.Printf("PUT request received for document update")
+	logger := h.logger(c)
 	id := c.Param("id")
 	documentID, err := uuid.Parse(id)
 	if err != nil {
-		log.Printf("Invalid document ID: %v", err)
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
 		return
 	}
-    log.Printf("Updating document: %s", documentID.String())
+	logger = logger.With("document_id", documentID)
 
 	var change models.TextChange
-	if err := c.ShouldBindJSON(&change); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	if !h.bindJSON(c, &change) {
+		return
+	}
+	// The author is whoever the session or API key says, not the body
+	userID, ok := h.requireUser(c)
+	if !ok {
 		return
 	}
-    if containsLinks(change.Content) {
-        c.JSON(http.StatusBadRequest, gin.H{"error": "Links are not allowed in content"})
-        return
-    }
-
-    // First, get the current document content
-    var currentContent string
-    err = h.db.QueryRow("SELECT COALESCE(content, '') FROM documents WHERE id = $1", documentID.String()).Scan(&currentContent)
-    if err != nil {
-        c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document content"})
-        return
-    }
-
-    // Calculate the new document content based on the change
-    originalContent := currentContent
-    var newDocumentContent string
-    // Prepare inverse change details for potential moderation revert
-    invType := change.ChangeType
-    invContent := ""
-    invLength := change.Length
-    switch change.ChangeType {
-    case "insert":
-        beforeText := ""
-        afterText := ""
-        if change.Position <= len(currentContent) {
-            beforeText = currentContent[:change.Position]
-            afterText = currentContent[change.Position:]
-        } else {
-            beforeText = currentContent
-        }
-        newDocumentContent = beforeText + change.Content + afterText
-        // inverse: delete the inserted text
-        invType = "delete"
-        invContent = ""
-        invLength = len(change.Content)
-    case "delete":
-        beforeText := ""
-        afterText := ""
-        if change.Position < len(currentContent) {
-            beforeText = currentContent[:change.Position]
-            endPos := change.Position + change.Length
-            if endPos <= len(currentContent) {
-                // capture deleted segment for potential revert
-                invContent = currentContent[change.Position:endPos]
-                afterText = currentContent[endPos:]
-            }
-        } else {
-            beforeText = currentContent
-        }
-        newDocumentContent = beforeText + afterText
-        // inverse: insert the deleted segment back
-        invType = "insert"
-        // invContent set above if available (empty if out of range)
-        invLength = 0
-    case "replace":
-        beforeText := ""
-        afterText := ""
-        if change.Position < len(currentContent) {
-            beforeText = currentContent[:change.Position]
-            endPos := change.Position + change.Length
-            if endPos <= len(currentContent) {
-                // capture replaced segment for potential revert
-                invContent = currentContent[change.Position:endPos]
-                afterText = currentContent[endPos:]
-            }
-        } else {
-            beforeText = currentContent
-        }
-        newDocumentContent = beforeText + change.Content + afterText
-        // inverse: replace inserted content with previous segment
-        invType = "replace"
-        invLength = len(change.Content)
-    default:
-        newDocumentContent = currentContent
-    }
-
-    // Update the document content
-    log.Printf("Updating document content...")
-	_, err = h.db.Exec(
-		"UPDATE documents SET content = $1, updated_at = $2 WHERE id = $3",
+	change.UserID = userID
+	if key, isBot := requestAPIKey(c); isBot {
+		change.UserName = key.Name
+	}
+	if _, ok := h.authorize(c, documentID, change.UserID, auth.RoleEditor); !ok {
+		return
+	}
+	if _, ok := h.applyChange(c, logger, documentID, change, nil, true); !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// applyChange checks a change against the content policy, locks and chain
+// rules, commits it, and tells clients about it; moderation then runs in the
+// background. Failures are written to c. A non-nil base is the revision the
+// change's position refers to, and the change is rebased from it onto the
+// current content. direct is true for edits the caller makes itself, which
+// take the caller's chain turn and, for bots, the edit cooldown once every
+// other check has passed; it is false for accepted suggestions.
+func (h *Handler) applyChange(c *gin.Context, logger *slog.Logger, documentID uuid.UUID, change models.TextChange, base *int64, direct bool) (uuid.UUID, bool) {
+	// The document row stays locked from reading the content to saving the
+	// change, so concurrent edits commit one after the other
+	tx, err := h.db.Begin()
+	if err != nil {
+		logger.Error("failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return uuid.Nil, false
+	}
+	defer tx.Rollback()
+
+	var currentContent string
+	var currentRevision int64
+	var chain models.ChainSettings
+	err = tx.QueryRow(
+		"SELECT COALESCE(content, ''), revision, chain_mode, chain_unit, turn_seconds FROM documents WHERE id = $1 FOR UPDATE",
+		documentID.String(),
+	).Scan(&currentContent, &currentRevision, &chain.Enabled, &chain.Unit, &chain.TurnSeconds)
+	if err != nil {
+		logger.Error("failed to get document content", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document content"})
+		return uuid.Nil, false
+	}
+	if base != nil && *base < currentRevision {
+		rebased, ok, err := h.rebaseFrom(tx, documentID, change, *base)
+		if err != nil {
+			logger.Error("failed to rebase change", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebase change"})
+			return uuid.Nil, false
+		}
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    "The text this change edits has been edited since",
+				"code":     "edit_conflict",
+				"revision": currentRevision,
+			})
+			return uuid.Nil, false
+		}
+		change = rebased
+	}
+	if violation := policy.Check(h.cfg.Policy, change, currentContent); violation != nil {
+		logger.Info("edit rejected by policy", "user_id", change.UserID, "code", violation.Code)
+		metrics.PolicyRejections.WithLabelValues(violation.Code).Inc()
+		c.JSON(http.StatusBadRequest, violation)
+		return uuid.Nil, false
+	}
+	locks, err := h.documentLocks(tx, documentID)
+	if err != nil {
+		logger.Error("failed to get document locks", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document locks"})
+		return uuid.Nil, false
+	}
+	applied := textops.Normalize(change, len(currentContent))
+	if lock := lockedBy(locks, applied); lock != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This part of the document is locked", "code": "region_locked", "lock": lock})
+		return uuid.Nil, false
+	}
+	if chain.Enabled {
+		if violation := policy.CheckChain(chain, change); violation != nil {
+			metrics.PolicyRejections.WithLabelValues(violation.Code).Inc()
+			c.JSON(http.StatusBadRequest, violation)
+			return uuid.Nil, false
+		}
+	}
+	// Rejected edits leave the cooldown and the turn untouched
+	if key, isBot := requestAPIKey(c); direct && isBot && !h.takeEditCooldown(c, key) {
+		return uuid.Nil, false
+	}
+	// The turn belongs to the verified caller, never to an ID a client sent.
+	// It is given back if the edit then fails to save.
+	returnTurn := func() {}
+	if chain.Enabled && direct {
+		turn, giveBack, ok := h.hub.TakeTurn(documentID, requestUser(c))
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{"error": "It is not your turn", "code": "not_your_turn", "turn": turn})
+			return uuid.Nil, false
+		}
+		returnTurn = giveBack
+	}
+
+	// Calculate the new document content based on the change
+	originalContent := currentContent
+	newDocumentContent := textops.Apply(currentContent, applied)
+	removed := textops.Removed(currentContent, applied)
+
+	// Update the document content
+	var revision int64
+	err = tx.QueryRow(
+		"UPDATE documents SET content = $1, updated_at = $2, revision = revision + 1 WHERE id = $3 RETURNING revision",
 		newDocumentContent, time.Now(), documentID.String(),
-	)
+	).Scan(&revision)
 	if err != nil {
-		log.Printf("Failed to update document content: %v", err)
+		returnTurn()
+		logger.Error("failed to update document content", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
-		return
+		return uuid.Nil, false
 	}
-	log.Printf("Document content updated successfully")
-	
 
-	// Save the change to the changes table
-	log.Printf("Saving change to database...")
+	// Save the change as applied, so history can be replayed exactly
 	changeID := uuid.New()
-	_, err = h.db.Exec(
-		`INSERT INTO changes (id, document_id, user_id, user_name, change_type, content, position, length, timestamp)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-		changeID.String(), documentID.String(), change.UserID.String(), change.UserName, change.ChangeType,
-		change.Content, change.Position, change.Length, time.Now(),
+	committedAt := time.Now()
+	_, err = tx.Exec(
+		`INSERT INTO changes (id, document_id, user_id, user_name, change_type, content, position, length, timestamp, revision, removed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		changeID.String(), documentID.String(), change.UserID.String(), change.UserName, applied.ChangeType,
+		applied.Content, applied.Position, applied.Length, committedAt, revision, removed,
 	)
-
+	// Anchored ranges move with the commit, so the next edit sees them moved
+	var shifted shiftedAnchors
+	if err == nil {
+		shifted, err = h.shiftAnchors(tx, documentID, applied, revision)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
-		log.Printf("Failed to save change: %v", err)
+		returnTurn()
+		logger.Error("failed to save change", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save change"})
-		return
+		return uuid.Nil, false
 	}
-	log.Printf("Change saved to database successfully")
+	h.blame.Apply(documentID, applied, changeID, committedAt, revision)
+	h.recordContribution(logger, documentID, applied, removed, committedAt)
+	metrics.Edits.WithLabelValues(applied.ChangeType).Inc()
+	logger.Info("change committed",
+		"change_id", changeID,
+		"user_id", change.UserID,
+		"change_type", applied.ChangeType,
+		"position", applied.Position,
+		"length", applied.Length,
+		"revision", revision,
+	)
+
+	// Keep tracked cursors pointing at the same text
+	h.hub.TransformCursors(documentID, applied)
+	h.broadcastAnchors(documentID, revision, shifted)
+	h.emit(logger, documentID, webhooks.ChangeCommitted, gin.H{
+		"change_id":   changeID,
+		"user_id":     change.UserID,
+		"user_name":   change.UserName,
+		"change_type": applied.ChangeType,
+		"content":     applied.Content,
+		"position":    applied.Position,
+		"length":      applied.Length,
+		"removed":     removed,
+		"revision":    revision,
+	})
 
-    // Broadcast the change to all WebSocket clients
-    log.Printf("Broadcasting change to WebSocket clients...")
-    go func() {
-        wsMessage := models.WebSocketMessage{
-            Type: "text_change",
-            Data: map[string]interface{}{
+	// Broadcast the change as stored, so live clients match history and replay
+	h.background.Add(1)
+	go func() {
+		defer h.background.Done()
+		wsMessage := models.WebSocketMessage{
+			Type: "text_change",
+			Data: map[string]interface{}{
 				"changeID":   changeID.String(),
 				"documentId": documentID.String(),
-				"userID":     change.UserID.String(),
-				"userName":   change.UserName,
-				"changeType": change.ChangeType,
-				"content":    change.Content,
-				"position":   change.Position,
-				"length":     change.Length,
+				"userID":     applied.UserID.String(),
+				"userName":   applied.UserName,
+				"changeType": applied.ChangeType,
+				"content":    applied.Content,
+				"position":   applied.Position,
+				"length":     applied.Length,
+				"revision":   revision,
 			},
 		}
 
-        if wsData, err := json.Marshal(wsMessage); err == nil {
-            h.hub.Broadcast <- wsData
-            log.Printf("Broadcasted change to WebSocket clients: ID=%s", changeID.String())
-        } else {
-            log.Printf("Failed to marshal WebSocket message: %v", err)
-        }
-    }()
-
-    log.Printf("Document update completed successfully for ID: %s", documentID.String())
-    // Respond immediately; moderation happens asynchronously
-    c.JSON(http.StatusOK, gin.H{"success": true})
-
-    // Post-commit profanity check and potential revert (async)
-    go func(orig string, ch models.TextChange, invType string, invContent string, invLength int) {
-        trimmed := strings.TrimSpace(ch.Content)
-        if trimmed == "" || (ch.ChangeType != "insert" && ch.ChangeType != "replace") {
-            return
-        }
-        prev, next := getSurroundingWords(orig, ch.Position, 3)
-        ctxMsg := strings.TrimSpace(strings.Join(append(append(prev, trimmed), next...), " "))
-        if ctxMsg == "" {
-            return
-        }
-
-        if profanityDebugEnabled() {
-            // Do not log raw user content; log only meta
-            log.Printf("[Profanity] Post-check start: ctx_len=%d", len(ctxMsg))
-        }
-
-        profane, err := checkProfanityHTTP(ctxMsg)
-        if err != nil {
-            log.Printf("Post-check profanity error: %v", err)
-            return
-        }
-        if !profane {
-            if profanityDebugEnabled() {
-                log.Printf("[Profanity] Clean: no action")
-            }
-            return
-        }
-
-        // Revert: write original content back
-        if _, err := h.db.Exec(
-            "UPDATE documents SET content = $1, updated_at = $2 WHERE id = $3",
-            orig, time.Now(), documentID.String(),
-        ); err != nil {
-            log.Printf("Failed to revert document after profanity: %v", err)
-            return
-        }
-
-        // Record revert change
-        revertID := uuid.New()
-        sysUser := uuid.Nil // 0000-...
-        sysName := "System (moderation)"
-        if _, err := h.db.Exec(
-            `INSERT INTO changes (id, document_id, user_id, user_name, change_type, content, position, length, timestamp)
-             VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
-            revertID.String(), documentID.String(), sysUser.String(), sysName, invType,
-            invContent, ch.Position, invLength, time.Now(),
-        ); err != nil {
-            log.Printf("Failed to save revert change: %v", err)
-        }
-
-        // Broadcast inverse change so clients update immediately
-        msg := models.WebSocketMessage{
-            Type: "text_change",
-            Data: map[string]interface{}{
-                "changeID":   revertID.String(),
-                "documentId": documentID.String(),
-                "userID":     sysUser.String(),
-                "userName":   sysName,
-                "changeType": invType,
-                "content":    invContent,
-                "position":   ch.Position,
-                "length":     invLength,
-            },
-        }
-        if wsData, err := json.Marshal(msg); err == nil {
-            h.hub.Broadcast <- wsData
-            log.Printf("Broadcasted moderation revert: ID=%s", revertID.String())
-        }
-    }(originalContent, change, invType, invContent, invLength)
+		if wsData, err := json.Marshal(wsMessage); err == nil {
+			h.hub.BroadcastToDocument(documentID, wsData)
+			logger.Debug("broadcasted change", "change_id", changeID)
+		} else {
+			logger.Error("failed to marshal websocket message", "error", err)
+		}
+	}()
+
+	// Moderation happens asynchronously so the caller can respond immediately
+	// Post-commit profanity check and potential revert (async)
+	h.background.Add(1)
+	go func(orig string, ch models.TextChange) {
+		defer h.background.Done()
+		if !h.cfg.Moderation.Enabled {
+			return
+		}
+		trimmed := strings.TrimSpace(ch.Content)
+		if trimmed == "" || (ch.ChangeType != "insert" && ch.ChangeType != "replace") {
+			return
+		}
+		prev, next := getSurroundingWords(orig, ch.Position, 3)
+		ctxMsg := strings.TrimSpace(strings.Join(append(append(prev, trimmed), next...), " "))
+		if ctxMsg == "" {
+			return
+		}
+
+		logger.Debug("profanity post-check start", "ctx_len", len(ctxMsg))
+
+		start := time.Now()
+		profane, err := h.moderator.Check(h.backgroundCtx, logger, ctxMsg)
+		metrics.ModerationDuration.Observe(time.Since(start).Seconds())
+		if err != nil {
+			metrics.ModerationVerdicts.WithLabelValues("error").Inc()
+			logger.Warn("profanity post-check failed", "error", err)
+			return
+		}
+		if !profane {
+			metrics.ModerationVerdicts.WithLabelValues("clean").Inc()
+			logger.Debug("profanity check clean")
+			return
+		}
+		metrics.ModerationVerdicts.WithLabelValues("profane").Inc()
+		h.emit(logger, documentID, webhooks.ModerationFlagged, gin.H{
+			"change_id": changeID,
+			"user_id":   ch.UserID,
+			"user_name": ch.UserName,
+			"content":   ch.Content,
+		})
+
+		revertID, err := h.revertChange(logger, documentID, changeID, "System (moderation)")
+		if err != nil {
+			logger.Error("failed to revert change after profanity", "change_id", changeID, "error", err)
+			return
+		}
+		logger.Info("moderation reverted change", "change_id", changeID, "revert_id", revertID)
+	}(originalContent, applied)
+
+	return changeID, true
+}
+
+// Errors from revertChange for changes it leaves alone.
+var (
+	errAlreadyReverted = errors.New("change is already reverted")
+	errRevertConflict  = errors.New("change was edited since and cannot be reverted cleanly")
+)
+
+// revertChange commits the inverse of a change as the system user, rebased
+// over everything committed since, and broadcasts it like any other edit.
+// Moderation and vote-based curation both revert this way.
+func (h *Handler) revertChange(logger *slog.Logger, documentID, changeID uuid.UUID, userName string) (uuid.UUID, error) {
+	// Claim the change first so concurrent reverts cannot both apply
+	revertID := uuid.New()
+	var target models.TextChange
+	var removed string
+	var base sql.NullInt64
+	err := h.db.QueryRow(
+		`UPDATE changes SET reverted_by = $1 WHERE id = $2 AND document_id = $3 AND reverted_by IS NULL
+		RETURNING user_id, change_type, content, position, length, removed, revision`,
+		revertID.String(), changeID.String(), documentID.String(),
+	).Scan(&target.UserID, &target.ChangeType, &target.Content, &target.Position, &target.Length, &removed, &base)
+	if err == sql.ErrNoRows {
+		return uuid.Nil, errAlreadyReverted
+	} else if err != nil {
+		return uuid.Nil, err
+	}
+
+	reverted, revision, err := h.commitRevert(documentID, revertID, textops.Invert(target, removed), base, userName)
+	if err != nil {
+		if _, resetErr := h.db.Exec("UPDATE changes SET reverted_by = NULL WHERE id = $1", changeID.String()); resetErr != nil {
+			logger.Error("failed to release reverted change", "change_id", changeID, "error", resetErr)
+		}
+		return uuid.Nil, err
+	}
+
+	h.recordRevert(logger, documentID, target.UserID)
+	h.hub.TransformCursors(documentID, reverted)
+	h.emit(logger, documentID, webhooks.ChangeReverted, gin.H{
+		"change_id":   revertID,
+		"reverts":     changeID,
+		"author_id":   target.UserID,
+		"user_name":   userName,
+		"change_type": reverted.ChangeType,
+		"content":     reverted.Content,
+		"position":    reverted.Position,
+		"length":      reverted.Length,
+		"revision":    revision,
+	})
+
+	// Broadcast inverse change so clients update immediately
+	msg := models.WebSocketMessage{
+		Type: "text_change",
+		Data: map[string]interface{}{
+			"changeID":   revertID.String(),
+			"documentId": documentID.String(),
+			"userID":     uuid.Nil.String(),
+			"userName":   userName,
+			"changeType": reverted.ChangeType,
+			"content":    reverted.Content,
+			"position":   reverted.Position,
+			"length":     reverted.Length,
+			"revision":   revision,
+			"reverts":    changeID.String(),
+		},
+	}
+	if wsData, err := json.Marshal(msg); err == nil {
+		h.hub.BroadcastToDocument(documentID, wsData)
+	}
+	return revertID, nil
+}
+
+// commitRevert rebases an inverse change from base onto the current content
+// and saves it as revertID, returning it as applied and the new revision.
+func (h *Handler) commitRevert(documentID, revertID uuid.UUID, inverse models.TextChange, base sql.NullInt64, userName string) (models.TextChange, int64, error) {
+	if !base.Valid {
+		// Changes from before revisions were tracked cannot be rebased
+		return inverse, 0, errRevertConflict
+	}
+
+	// As in applyChange, the document row is locked until the revert is saved
+	tx, err := h.db.Begin()
+	if err != nil {
+		return inverse, 0, err
+	}
+	defer tx.Rollback()
+	var content string
+	if err := tx.QueryRow(
+		"SELECT COALESCE(content, '') FROM documents WHERE id = $1 FOR UPDATE", documentID.String(),
+	).Scan(&content); err != nil {
+		return inverse, 0, err
+	}
+	inverse, ok, err := h.rebaseFrom(tx, documentID, inverse, base.Int64)
+	if err != nil {
+		return inverse, 0, err
+	}
+	if !ok {
+		return inverse, 0, errRevertConflict
+	}
+	inverse = textops.Normalize(inverse, len(content))
+	inverse.UserID, inverse.UserName = uuid.Nil, userName
+
+	var revision int64
+	if err := tx.QueryRow(
+		"UPDATE documents SET content = $1, updated_at = $2, revision = revision + 1 WHERE id = $3 RETURNING revision",
+		textops.Apply(content, inverse), time.Now(), documentID.String(),
+	).Scan(&revision); err != nil {
+		return inverse, 0, err
+	}
+	committedAt := time.Now()
+	_, err = tx.Exec(
+		`INSERT INTO changes (id, document_id, user_id, user_name, change_type, content, position, length, timestamp, revision, removed)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		revertID.String(), documentID.String(), uuid.Nil.String(), userName, inverse.ChangeType,
+		inverse.Content, inverse.Position, inverse.Length, committedAt, revision, textops.Removed(content, inverse),
+	)
+	var shifted shiftedAnchors
+	if err == nil {
+		shifted, err = h.shiftAnchors(tx, documentID, inverse, revision)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return inverse, 0, err
+	}
+	h.broadcastAnchors(documentID, revision, shifted)
+	h.blame.Apply(documentID, inverse, revertID, committedAt, revision)
+	return inverse, revision, nil
 }
 
 func (h *Handler) getChanges(c *gin.Context) {
@@ -322,246 +668,179 @@ func (h *Handler) getChanges(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
 		return
 	}
+	if _, ok := h.authorize(c, docID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
 
 	var changes []models.Change
-	
+
 	// Use direct string interpolation to completely avoid prepared statements
-    log.Printf("Querying changes for document: %s", docID.String())
-	query := fmt.Sprintf("SELECT id, document_id, user_id, user_name, change_type, content, position, length, timestamp FROM changes WHERE document_id = '%s' ORDER BY timestamp DESC LIMIT 50", docID.String())
+	// Votes count as in voteTally, only from voters holding an explicit role
+	voters := "'" + strings.Join(voterRoles, "', '") + "'"
+	query := fmt.Sprintf(`SELECT id, document_id, user_id, user_name, change_type, content, position, length, timestamp, COALESCE(revision, 0), reverted_by,
+		(SELECT COUNT(*) FROM change_votes v JOIN document_roles r ON r.user_id = v.user_id AND r.document_id = changes.document_id
+			WHERE v.change_id = changes.id AND v.value > 0 AND r.role IN (%[3]s)),
+		(SELECT COUNT(*) FROM change_votes v JOIN document_roles r ON r.user_id = v.user_id AND r.document_id = changes.document_id
+			WHERE v.change_id = changes.id AND v.value < 0 AND r.role IN (%[3]s))
+		FROM changes WHERE document_id = '%[1]s' ORDER BY timestamp DESC LIMIT %[2]d`, docID.String(), h.cfg.History.Limit, voters)
 	rows, err := h.db.Query(query)
 	if err != nil {
-		log.Printf("Failed to query changes: %v", err)
+		h.logger(c).Error("failed to query changes", "document_id", docID, "error", err)
 		c.JSON(http.StatusOK, changes) // Return empty array instead of error
 		return
 	}
 	defer rows.Close()
-	log.Printf("Changes query executed successfully")
 
 	for rows.Next() {
 		var change models.Change
+		var revertedBy uuid.NullUUID
 		err := rows.Scan(
 			&change.ID, &change.DocumentID, &change.UserID, &change.UserName,
-			&change.ChangeType, &change.Content, &change.Position, &change.Length, &change.Timestamp,
+			&change.ChangeType, &change.Content, &change.Position, &change.Length, &change.Timestamp, &change.Revision,
+			&revertedBy, &change.Upvotes, &change.Downvotes,
 		)
 		if err != nil {
-			log.Printf("Failed to scan change: %v", err)
+			h.logger(c).Error("failed to scan change", "document_id", docID, "error", err)
 			continue
 		}
+		if revertedBy.Valid {
+			change.RevertedBy = &revertedBy.UUID
+		}
 		changes = append(changes, change)
 	}
 
 	if err := rows.Err(); err != nil {
-		log.Printf("Row iteration error: %v", err)
+		h.logger(c).Error("change row iteration failed", "document_id", docID, "error", err)
 	}
 
 	c.JSON(http.StatusOK, changes)
 }
 
-func (h *Handler) getStats(c *gin.Context) {
-	var totalEdits int
-	h.db.QueryRow("SELECT COUNT(*) FROM changes").Scan(&totalEdits)
+func (h *Handler) getPresence(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
 
-	var uniqueUsers int
-	h.db.QueryRow("SELECT COUNT(DISTINCT user_id) FROM changes").Scan(&uniqueUsers)
+	users := h.hub.Presence(documentID)
+	c.JSON(http.StatusOK, gin.H{
+		"document_id":  documentID,
+		"users":        users,
+		"online_count": len(users),
+	})
+}
 
-	onlineCount := h.hub.GetOnlineCount()
+func (h *Handler) getStats(c *gin.Context) {
+	c.JSON(http.StatusOK, h.stats())
+}
 
-	stats := gin.H{
-		"total_edits":   totalEdits,
-		"unique_users":  uniqueUsers,
-		"online_count":  onlineCount,
-	}
+func (h *Handler) stats() models.Stats {
+	var stats models.Stats
+	h.db.QueryRow("SELECT COUNT(*) FROM changes").Scan(&stats.TotalEdits)
+	h.db.QueryRow("SELECT COUNT(DISTINCT user_id) FROM changes").Scan(&stats.UniqueUsers)
+	stats.OnlineCount = h.hub.GetOnlineCount()
+	return stats
+}
 
-	c.JSON(http.StatusOK, stats)
+// BroadcastStats sends stats_update to every client when the stats change,
+// checking every statsInterval while anyone is connected, until ctx is
+// cancelled.
+func (h *Handler) BroadcastStats(ctx context.Context) {
+	ticker := time.NewTicker(statsInterval)
+	defer ticker.Stop()
+	var last models.Stats
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if h.hub.GetOnlineCount() == 0 {
+			continue
+		}
+		stats := h.stats()
+		if stats == last {
+			continue
+		}
+		last = stats
+		data, err := json.Marshal(models.WebSocketMessage{Type: "stats_update", Data: stats})
+		if err != nil {
+			continue
+		}
+		select {
+		case h.hub.Broadcast <- data:
+		case <-ctx.Done():
+			return
+		}
+	}
 }
 
-func containsLinks(content string) bool {
-	urlRegex := `(?i)https?://[^\s<>"{}|\\^` + "`" + `\[\]]+|www\.[^\s<>"{}|\\^` + "`" + `\[\]]+|ftp://[^\s<>"{}|\\^` + "`" + `\[\]]+`
-	emailRegex := `\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Z|a-z]{2,}\b`
-	
-	matched, _ := regexp.MatchString(urlRegex, content)
-	if matched {
+// bindJSON decodes the request body into dst, enforcing the policy's
+// body size limit. It writes the error response and returns false on failure.
+func (h *Handler) bindJSON(c *gin.Context, dst any) bool {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.Policy.MaxBodyBytes)
+	err := c.ShouldBindJSON(dst)
+	if err == nil {
 		return true
 	}
-	
-	matched, _ = regexp.MatchString(emailRegex, content)
-	return matched
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{
+			"error": fmt.Sprintf("Request body may be at most %d bytes", tooLarge.Limit),
+			"code":  "request_too_large",
+			"limit": tooLarge.Limit,
+		})
+		return false
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+	return false
 }
 
 // getSurroundingWords extracts up to `n` words before and after the position `pos`.
 func getSurroundingWords(text string, pos int, n int) ([]string, []string) {
-    re := regexp.MustCompile(`\S+`)
-    idxs := re.FindAllStringIndex(text, -1)
-    words := re.FindAllString(text, -1)
-    if len(idxs) != len(words) || len(words) == 0 {
-        return []string{}, []string{}
-    }
-
-    // Find center token index around pos
-    center := -1
-    for i, bounds := range idxs {
-        start, end := bounds[0], bounds[1]
-        if pos >= start && pos <= end {
-            center = i
-            break
-        }
-        if pos < start {
-            center = i - 1
-            break
-        }
-    }
-    if center == -1 {
-        center = len(words) - 1
-    }
-
-    // Collect up to n previous and next words
-    prevStart := center - n
-    if prevStart < 0 { prevStart = 0 }
-    prev := []string{}
-    for i := prevStart; i < center; i++ {
-        if i >= 0 && i < len(words) {
-            prev = append(prev, words[i])
-        }
-    }
-
-    next := []string{}
-    for i := center + 1; i <= center + n && i < len(words); i++ {
-        next = append(next, words[i])
-    }
-    return prev, next
-}
+	re := regexp.MustCompile(`\S+`)
+	idxs := re.FindAllStringIndex(text, -1)
+	words := re.FindAllString(text, -1)
+	if len(idxs) != len(words) || len(words) == 0 {
+		return []string{}, []string{}
+	}
 
-// checkProfanityHTTP calls the external profanity service.
-func checkProfanityHTTP(message string) (bool, error) {
-    payload := map[string]string{"message": message}
-    b, _ := json.Marshal(payload)
-
-    req, err := http.NewRequest("POST", "https://vector.profanity.dev", bytes.NewReader(b))
-    if err != nil {
-        return false, err
-    }
-    req.Header.Set("Content-Type", "application/json")
-
-    client := &http.Client{ Timeout: 3 * time.Second }
-    if profanityDebugEnabled() {
-        log.Printf("[Profanity] Sending request: bytes=%d", len(b))
-    }
-    resp, err := client.Do(req)
-    if err != nil {
-        if profanityDebugEnabled() {
-            log.Printf("[Profanity] Request error: %v", err)
-        }
-        return false, err
-    }
-    defer resp.Body.Close()
-
-    body, _ := io.ReadAll(resp.Body)
-    if profanityDebugEnabled() {
-        log.Printf("[Profanity] Response status=%d body=\"%s\"", resp.StatusCode, trimForLog(string(body), 200))
-    }
-
-    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-        // Treat non-2xx as non-blocking
-        return false, nil
-    }
-
-    var data interface{}
-    if err := json.Unmarshal(body, &data); err != nil {
-        if profanityDebugEnabled() {
-            log.Printf("[Profanity] JSON parse failed: %v", err)
-        }
-        return false, nil
-    }
-
-    // Interpret common shapes
-    if b, ok := data.(bool); ok {
-        if profanityDebugEnabled() {
-            log.Printf("[Profanity] Parsed boolean: %v", b)
-        }
-        return b, nil
-    }
-    if m, ok := data.(map[string]interface{}); ok {
-        // direct boolean-like keys
-        for key, v := range m {
-            lk := strings.ToLower(strings.ReplaceAll(key, "_", ""))
-            if lk == "isprofanity" || lk == "isprofane" || lk == "profanity" || lk == "flagged" || lk == "containsprofanity" {
-                if vb, ok := v.(bool); ok {
-                    if profanityDebugEnabled() { log.Printf("[Profanity] Parsed key %s: %v", key, vb) }
-                    return vb, nil
-                }
-                if vs, ok := v.(string); ok {
-                    b := strings.EqualFold(vs, "true") || vs == "1"
-                    if profanityDebugEnabled() { log.Printf("[Profanity] Parsed key %s (string): %s -> %v", key, vs, b) }
-                    return b, nil
-                }
-                if vn, ok := v.(float64); ok {
-                    b := vn >= 0.5
-                    if profanityDebugEnabled() { log.Printf("[Profanity] Parsed key %s (number): %.3f -> %v", key, vn, b) }
-                    return b, nil
-                }
-            }
-        }
-        // flaggedFor presence
-        if v, ok := m["flaggedFor"]; ok {
-            if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
-                if profanityDebugEnabled() { log.Printf("[Profanity] flaggedFor present: %s -> profane", s) }
-                return true, nil
-            }
-        }
-        // label/result-like
-        for _, k := range []string{"label", "result", "prediction"} {
-            if v, ok := m[k]; ok {
-                if s, ok := v.(string); ok && strings.Contains(strings.ToLower(s), "profan") {
-                    if profanityDebugEnabled() {
-                        log.Printf("[Profanity] Parsed label %s indicates profane: %s", k, s)
-                    }
-                    return true, nil
-                }
-            }
-        }
-        // scalar score at top level
-        if v, ok := m["score"]; ok {
-            switch t := v.(type) {
-            case float64:
-                if t > 0.8 {
-                    if profanityDebugEnabled() {
-                        log.Printf("[Profanity] Top-level score=%.3f > 0.8 -> profane", t)
-                    }
-                    return true, nil
-                }
-            }
-        }
-        // scores map
-        if v, ok := m["scores"]; ok {
-            if scores, ok := v.(map[string]interface{}); ok {
-                for k, val := range scores {
-                    if strings.Contains(strings.ToLower(k), "profan") {
-                        switch t := val.(type) {
-                        case float64:
-                            if t > 0.8 {
-                                if profanityDebugEnabled() {
-                                    log.Printf("[Profanity] Score %s=%.3f > 0.8 -> profane", k, t)
-                                }
-                                return true, nil
-                            }
-                        }
-                    }
-                }
-            }
-        }
-    }
-
-    return false, nil
-}
+	// Find center token index around pos
+	center := -1
+	for i, bounds := range idxs {
+		start, end := bounds[0], bounds[1]
+		if pos >= start && pos <= end {
+			center = i
+			break
+		}
+		if pos < start {
+			center = i - 1
+			break
+		}
+	}
+	if center == -1 {
+		center = len(words) - 1
+	}
 
-func profanityDebugEnabled() bool {
-    v := strings.ToLower(os.Getenv("PROFANITY_DEBUG"))
-    return v == "1" || v == "true" || v == "yes"
-}
+	// Collect up to n previous and next words
+	prevStart := center - n
+	if prevStart < 0 {
+		prevStart = 0
+	}
+	prev := []string{}
+	for i := prevStart; i < center; i++ {
+		if i >= 0 && i < len(words) {
+			prev = append(prev, words[i])
+		}
+	}
 
-func trimForLog(s string, n int) string {
-    if len(s) <= n {
-        return s
-    }
-    return s[:n] + "…"
+	next := []string{}
+	for i := center + 1; i <= center+n && i < len(words); i++ {
+		next = append(next, words[i])
+	}
+	return prev, next
 }
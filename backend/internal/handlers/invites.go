@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// InviteTokenHeader carries an invite token on REST requests; websocket
+// clients pass it as the invite query parameter instead.
+const InviteTokenHeader = "X-Invite-Token"
+
+// Invite lifetimes, when the request does not set one and at most.
+const (
+	defaultInviteTTL = 7 * 24 * time.Hour
+	maxInviteTTL     = 30 * 24 * time.Hour
+)
+
+// errInviteUnusable covers invites that verify but are revoked, used up or
+// for another document.
+var errInviteUnusable = errors.New("invite is revoked, used up or for another document")
+
+// inviteToken returns the invite token sent with a request, if any.
+func inviteToken(c *gin.Context) string {
+	if token := c.GetHeader(InviteTokenHeader); token != "" {
+		return token
+	}
+	return c.Query("invite")
+}
+
+// redeemInvite grants the role of a valid invite token when it is higher
+// than current. Users with a session keep the role as an explicit role,
+// which counts one use, so they only use an invite once; an explicit role
+// it raises is kept so revoking the invite can restore it. Anonymous callers
+// get the role for the request only and use nothing.
+func (h *Handler) redeemInvite(c *gin.Context, documentID, userID uuid.UUID, current auth.Role) (auth.Role, error) {
+	claims, err := h.invites.Verify(inviteToken(c), time.Now())
+	if err != nil {
+		return current, err
+	}
+	if claims.DocumentID != documentID {
+		return current, errInviteUnusable
+	}
+	if current.AtLeast(claims.Role) {
+		return current, nil
+	}
+	logger := h.logger(c).With("document_id", documentID, "invite_id", claims.InviteID, "user_id", userID)
+
+	const usable = `id = $1 AND document_id = $2 AND revoked_at IS NULL AND expires_at > now()
+		AND (max_uses IS NULL OR uses < max_uses)`
+	var role string
+	if userID == uuid.Nil {
+		err = h.db.QueryRow(
+			"SELECT role FROM document_invites WHERE "+usable,
+			claims.InviteID.String(), documentID.String(),
+		).Scan(&role)
+		if err == sql.ErrNoRows {
+			return current, errInviteUnusable
+		} else if err != nil {
+			return current, err
+		}
+		logger.Debug("invite used anonymously", "role", role)
+		return auth.Role(role), nil
+	}
+
+	// The use and the role it grants are saved together or not at all
+	tx, err := h.db.Begin()
+	if err != nil {
+		return current, err
+	}
+	defer tx.Rollback()
+	var explicit string
+	err = tx.QueryRow(
+		"SELECT role FROM document_roles WHERE document_id = $1 AND user_id = $2 FOR UPDATE",
+		documentID.String(), userID.String(),
+	).Scan(&explicit)
+	if err != nil && err != sql.ErrNoRows {
+		return current, err
+	}
+	// A role set since current was resolved may already be as high
+	if err == nil && auth.Role(explicit).AtLeast(claims.Role) {
+		return auth.Role(explicit), nil
+	}
+	err = tx.QueryRow(
+		"UPDATE document_invites SET uses = uses + 1 WHERE "+usable+" RETURNING role",
+		claims.InviteID.String(), documentID.String(),
+	).Scan(&role)
+	if err == sql.ErrNoRows {
+		return current, errInviteUnusable
+	} else if err != nil {
+		return current, err
+	}
+	_, err = tx.Exec(
+		`INSERT INTO document_roles (document_id, user_id, role, invite_id, updated_at) VALUES ($1, $2, $3, $4, now())
+		ON CONFLICT (document_id, user_id) DO UPDATE SET
+			role = EXCLUDED.role,
+			invite_id = EXCLUDED.invite_id,
+			prior_role = CASE WHEN document_roles.invite_id IS NULL THEN document_roles.role ELSE document_roles.prior_role END,
+			updated_at = now()`,
+		documentID.String(), userID.String(), role, claims.InviteID.String(),
+	)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		return current, err
+	}
+	logger.Info("invite redeemed", "role", role)
+	return auth.Role(role), nil
+}
+
+func (h *Handler) createInvite(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	userID := requestUser(c)
+	if _, ok := h.authorize(c, documentID, userID, auth.RoleOwner); !ok {
+		return
+	}
+
+	var req struct {
+		Role             auth.Role `json:"role"`
+		ExpiresInSeconds int       `json:"expires_in_seconds"`
+		MaxUses          *int      `json:"max_uses"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	switch req.Role {
+	case auth.RoleViewer, auth.RoleCommenter, auth.RoleEditor:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be editor, commenter or viewer"})
+		return
+	}
+	ttl := defaultInviteTTL
+	if req.ExpiresInSeconds != 0 {
+		ttl = time.Duration(req.ExpiresInSeconds) * time.Second
+	}
+	if ttl <= 0 || ttl > maxInviteTTL {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("Invites may last at most %d seconds", int(maxInviteTTL.Seconds())),
+		})
+		return
+	}
+	if req.MaxUses != nil && *req.MaxUses < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Max uses must be at least 1"})
+		return
+	}
+
+	invite := models.Invite{
+		ID:         uuid.New(),
+		DocumentID: documentID,
+		Role:       string(req.Role),
+		ExpiresAt:  time.Now().Add(ttl).Truncate(time.Second),
+		MaxUses:    req.MaxUses,
+	}
+	if userID != uuid.Nil {
+		invite.CreatedBy = &userID
+	}
+	err = h.db.QueryRow(
+		`INSERT INTO document_invites (id, document_id, role, created_by, expires_at, max_uses)
+		SELECT $1, id, $3, $4, $5, $6 FROM documents WHERE id = $2
+		RETURNING created_at`,
+		invite.ID.String(), documentID.String(), invite.Role, invite.CreatedBy, invite.ExpiresAt, invite.MaxUses,
+	).Scan(&invite.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to create invite", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create invite"})
+		return
+	}
+
+	token := h.invites.Sign(auth.InviteClaims{
+		InviteID:   invite.ID,
+		DocumentID: documentID,
+		Role:       req.Role,
+		ExpiresAt:  invite.ExpiresAt.Unix(),
+	})
+	logger.Info("invite created", "document_id", documentID, "invite_id", invite.ID, "role", invite.Role)
+	c.JSON(http.StatusCreated, gin.H{"invite": invite, "token": token})
+}
+
+func (h *Handler) getInvites(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	rows, err := h.db.Query(
+		`SELECT id, document_id, role, created_by, expires_at, max_uses, uses, revoked_at, created_at
+		FROM document_invites WHERE document_id = $1 ORDER BY created_at DESC`,
+		documentID.String(),
+	)
+	if err != nil {
+		h.logger(c).Error("failed to query invites", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invites"})
+		return
+	}
+	defer rows.Close()
+
+	invites := []models.Invite{}
+	for rows.Next() {
+		var invite models.Invite
+		var createdBy uuid.NullUUID
+		var maxUses sql.NullInt64
+		var revokedAt sql.NullTime
+		err := rows.Scan(
+			&invite.ID, &invite.DocumentID, &invite.Role, &createdBy, &invite.ExpiresAt,
+			&maxUses, &invite.Uses, &revokedAt, &invite.CreatedAt,
+		)
+		if err != nil {
+			h.logger(c).Error("failed to scan invite", "document_id", documentID, "error", err)
+			continue
+		}
+		if createdBy.Valid {
+			invite.CreatedBy = &createdBy.UUID
+		}
+		if maxUses.Valid {
+			n := int(maxUses.Int64)
+			invite.MaxUses = &n
+		}
+		if revokedAt.Valid {
+			invite.RevokedAt = &revokedAt.Time
+		}
+		invites = append(invites, invite)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"document_id": documentID, "invites": invites})
+}
+
+func (h *Handler) revokeInvite(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	inviteID, err := uuid.Parse(c.Param("inviteId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invite ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	// Revoking also takes back the roles the invite granted, restoring any
+	// explicit role it raised
+	tx, err := h.db.Begin()
+	if err != nil {
+		logger.Error("failed to begin transaction", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+	defer tx.Rollback()
+	result, err := tx.Exec(
+		"UPDATE document_invites SET revoked_at = now() WHERE id = $1 AND document_id = $2 AND revoked_at IS NULL",
+		inviteID.String(), documentID.String(),
+	)
+	if err != nil {
+		logger.Error("failed to revoke invite", "invite_id", inviteID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invite not found"})
+		return
+	}
+	var restored, removed int64
+	result, err = tx.Exec(
+		`UPDATE document_roles SET role = prior_role, prior_role = NULL, invite_id = NULL, updated_at = now()
+		WHERE document_id = $1 AND invite_id = $2 AND prior_role IS NOT NULL`,
+		documentID.String(), inviteID.String(),
+	)
+	if err == nil {
+		restored, _ = result.RowsAffected()
+		result, err = tx.Exec(
+			"DELETE FROM document_roles WHERE document_id = $1 AND invite_id = $2",
+			documentID.String(), inviteID.String(),
+		)
+	}
+	if err == nil {
+		removed, _ = result.RowsAffected()
+		err = tx.Commit()
+	}
+	if err != nil {
+		logger.Error("failed to revoke invite", "invite_id", inviteID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke invite"})
+		return
+	}
+
+	logger.Info("invite revoked", "document_id", documentID, "invite_id", inviteID, "roles_restored", restored, "roles_removed", removed)
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/textops"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// maxLockLabelLength matches the label column.
+const maxLockLabelLength = 100
+
+// documentLocks lists a document's locks in document order.
+func (h *Handler) documentLocks(q queryer, documentID uuid.UUID) ([]models.Lock, error) {
+	rows, err := q.Query(
+		`SELECT id, document_id, start_pos, end_pos, label, created_at
+		FROM document_locks WHERE document_id = $1 ORDER BY start_pos`,
+		documentID.String(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := []models.Lock{}
+	for rows.Next() {
+		var lock models.Lock
+		if err := rows.Scan(&lock.ID, &lock.DocumentID, &lock.Start, &lock.End, &lock.Label, &lock.CreatedAt); err != nil {
+			return nil, err
+		}
+		locks = append(locks, lock)
+	}
+	return locks, rows.Err()
+}
+
+// lockedBy returns the first lock a normalized change would edit, or nil.
+func lockedBy(locks []models.Lock, change models.TextChange) *models.Lock {
+	for i := range locks {
+		if textops.Touches(change, locks[i].Start, locks[i].End) {
+			return &locks[i]
+		}
+	}
+	return nil
+}
+
+// shiftLocks moves a document's locks across a normalized change so they
+// keep covering the same text, removing those whose text is gone entirely.
+// It returns the locks left, or nil if none moved.
+func (h *Handler) shiftLocks(q queryer, documentID uuid.UUID, change models.TextChange) ([]models.Lock, error) {
+	locks, err := h.documentLocks(q, documentID)
+	if err != nil {
+		return nil, err
+	}
+
+	moved := false
+	kept := locks[:0]
+	for _, lock := range locks {
+		start, end := textops.TransformRange(lock.Start, lock.End, change)
+		if start == lock.Start && end == lock.End {
+			kept = append(kept, lock)
+			continue
+		}
+		moved = true
+		if start >= end {
+			if _, err := q.Exec("DELETE FROM document_locks WHERE id = $1", lock.ID.String()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if _, err := q.Exec(
+			"UPDATE document_locks SET start_pos = $1, end_pos = $2 WHERE id = $3",
+			start, end, lock.ID.String(),
+		); err != nil {
+			return nil, err
+		}
+		lock.Start, lock.End = start, end
+		kept = append(kept, lock)
+	}
+
+	if !moved {
+		return nil, nil
+	}
+	return kept, nil
+}
+
+// broadcastLocks sends the current locks to the clients of a document.
+func (h *Handler) broadcastLocks(documentID uuid.UUID, locks []models.Lock) {
+	message := models.WebSocketMessage{
+		Type: "locks_update",
+		Data: models.LocksUpdate{DocumentID: documentID, Locks: locks},
+	}
+	if data, err := json.Marshal(message); err == nil {
+		h.hub.BroadcastToDocument(documentID, data)
+	}
+}
+
+func (h *Handler) getLocks(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
+
+	locks, err := h.documentLocks(h.db, documentID)
+	if err != nil {
+		h.logger(c).Error("failed to query locks", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get locks"})
+		return
+	}
+	c.JSON(http.StatusOK, models.LocksUpdate{DocumentID: documentID, Locks: locks})
+}
+
+func (h *Handler) createLock(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	var req struct {
+		Start *int   `json:"start"`
+		End   *int   `json:"end"`
+		Label string `json:"label"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	req.Label = strings.TrimSpace(req.Label)
+	if req.Start == nil || req.End == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Start and end are required"})
+		return
+	}
+	if len(req.Label) > maxLockLabelLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Label is too long"})
+		return
+	}
+
+	var contentLen int
+	err = h.db.QueryRow("SELECT OCTET_LENGTH(content) FROM documents WHERE id = $1", documentID.String()).Scan(&contentLen)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to get document length", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		return
+	}
+	if *req.Start < 0 || *req.End <= *req.Start || *req.End > contentLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lock must cover a non-empty range inside the document"})
+		return
+	}
+
+	lock := models.Lock{DocumentID: documentID, Start: *req.Start, End: *req.End, Label: req.Label}
+	err = h.db.QueryRow(
+		`INSERT INTO document_locks (document_id, start_pos, end_pos, label)
+		VALUES ($1, $2, $3, $4) RETURNING id, created_at`,
+		documentID.String(), lock.Start, lock.End, lock.Label,
+	).Scan(&lock.ID, &lock.CreatedAt)
+	if err != nil {
+		logger.Error("failed to create lock", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create lock"})
+		return
+	}
+	logger.Info("region locked", "document_id", documentID, "lock_id", lock.ID, "start", lock.Start, "end", lock.End)
+
+	if locks, err := h.documentLocks(h.db, documentID); err == nil {
+		h.broadcastLocks(documentID, locks)
+	}
+	c.JSON(http.StatusCreated, lock)
+}
+
+func (h *Handler) deleteLock(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	lockID, err := uuid.Parse(c.Param("lockId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid lock ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	result, err := h.db.Exec(
+		"DELETE FROM document_locks WHERE id = $1 AND document_id = $2",
+		lockID.String(), documentID.String(),
+	)
+	if err != nil {
+		logger.Error("failed to delete lock", "lock_id", lockID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete lock"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lock not found"})
+		return
+	}
+	logger.Info("region unlocked", "document_id", documentID, "lock_id", lockID)
+
+	if locks, err := h.documentLocks(h.db, documentID); err == nil {
+		h.broadcastLocks(documentID, locks)
+	}
+	c.Status(http.StatusNoContent)
+}
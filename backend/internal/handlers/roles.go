@@ -0,0 +1,263 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// requestUser is the caller's verified user ID: the bot user of its API
+// key, else the user of its session token, or uuid.Nil for anonymous
+// callers. Both are checked by identify before the handler runs.
+func requestUser(c *gin.Context) uuid.UUID {
+	if key, ok := requestAPIKey(c); ok {
+		return key.BotUserID
+	}
+	if userID, ok := c.Value(sessionContextKey).(uuid.UUID); ok {
+		return userID
+	}
+	return uuid.Nil
+}
+
+// roleFor resolves a user's role on a document: their explicit role if one
+// is stored, otherwise the document's default role. Documents that do not
+// exist yet are open to editors, since getDocument creates them on first read.
+func (h *Handler) roleFor(documentID, userID uuid.UUID) (auth.Role, error) {
+	var role string
+	err := h.db.QueryRow(
+		`SELECT COALESCE(
+			(SELECT role FROM document_roles WHERE document_id = $1 AND user_id = $2),
+			(SELECT default_role FROM documents WHERE id = $1),
+			$3)`,
+		documentID.String(), userID.String(), string(auth.RoleEditor),
+	).Scan(&role)
+	return auth.Role(role), err
+}
+
+// authorize checks that userID holds at least min on a document, writing the
+// error response if not. A request with the admin token acts as owner, and
+// one with an invite token gets the invite's role if that is higher.
+func (h *Handler) authorize(c *gin.Context, documentID, userID uuid.UUID, min auth.Role) (auth.Role, bool) {
+	if auth.IsAdmin(c, h.cfg.Server.AdminToken) {
+		return auth.RoleOwner, true
+	}
+
+	role, err := h.roleFor(documentID, userID)
+	if err != nil {
+		h.logger(c).Error("failed to resolve role", "document_id", documentID, "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return role, false
+	}
+	code := "forbidden"
+	if !role.AtLeast(min) && inviteToken(c) != "" {
+		role, err = h.redeemInvite(c, documentID, userID, role)
+		if err != nil {
+			h.logger(c).Info("invite rejected", "document_id", documentID, "error", err)
+			code = "invalid_invite"
+		}
+	}
+	if !role.AtLeast(min) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":    "You do not have permission to do this",
+			"code":     code,
+			"role":     role,
+			"required": min,
+		})
+		return role, false
+	}
+	return role, true
+}
+
+// viewableFilter returns an SQL condition, starting with AND, that keeps
+// the documents aliased d which the caller may view, appending its
+// parameter to args. Admins see every document.
+func (h *Handler) viewableFilter(c *gin.Context, args *[]any) string {
+	if auth.IsAdmin(c, h.cfg.Server.AdminToken) {
+		return ""
+	}
+	*args = append(*args, requestUser(c).String())
+	return fmt.Sprintf(` AND COALESCE(
+		(SELECT r.role FROM document_roles r WHERE r.document_id = d.id AND r.user_id = $%d),
+		d.default_role) <> '%s'`, len(*args), auth.RoleNone)
+}
+
+func (h *Handler) getRoles(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	var defaultRole string
+	err = h.db.QueryRow("SELECT default_role FROM documents WHERE id = $1", documentID.String()).Scan(&defaultRole)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		h.logger(c).Error("failed to get default role", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+		return
+	}
+
+	rows, err := h.db.Query(
+		"SELECT document_id, user_id, role, invite_id, updated_at FROM document_roles WHERE document_id = $1 ORDER BY updated_at",
+		documentID.String(),
+	)
+	if err != nil {
+		h.logger(c).Error("failed to query roles", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get roles"})
+		return
+	}
+	defer rows.Close()
+
+	roles := []models.DocumentRole{}
+	for rows.Next() {
+		var role models.DocumentRole
+		var inviteID uuid.NullUUID
+		if err := rows.Scan(&role.DocumentID, &role.UserID, &role.Role, &inviteID, &role.UpdatedAt); err != nil {
+			h.logger(c).Error("failed to scan role", "document_id", documentID, "error", err)
+			continue
+		}
+		if inviteID.Valid {
+			role.InviteID = &inviteID.UUID
+		}
+		roles = append(roles, role)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"document_id":  documentID,
+		"default_role": defaultRole,
+		"roles":        roles,
+	})
+}
+
+func (h *Handler) setRole(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	var req struct {
+		Role auth.Role `json:"role"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be owner, editor, commenter, viewer or none"})
+		return
+	}
+
+	result, err := h.db.Exec(
+		`INSERT INTO document_roles (document_id, user_id, role, updated_at)
+		SELECT id, $2, $3, now() FROM documents WHERE id = $1
+		ON CONFLICT (document_id, user_id) DO UPDATE SET role = EXCLUDED.role, invite_id = NULL, prior_role = NULL, updated_at = now()`,
+		documentID.String(), userID.String(), string(req.Role),
+	)
+	if err != nil {
+		logger.Error("failed to set role", "document_id", documentID, "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set role"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	logger.Info("role set", "document_id", documentID, "user_id", userID, "role", req.Role)
+	c.JSON(http.StatusOK, gin.H{"document_id": documentID, "user_id": userID, "role": req.Role})
+}
+
+func (h *Handler) deleteRole(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	userID, err := uuid.Parse(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	result, err := h.db.Exec(
+		"DELETE FROM document_roles WHERE document_id = $1 AND user_id = $2",
+		documentID.String(), userID.String(),
+	)
+	if err != nil {
+		logger.Error("failed to delete role", "document_id", documentID, "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete role"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role not found"})
+		return
+	}
+
+	logger.Info("role removed", "document_id", documentID, "user_id", userID)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) updateAccess(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleOwner); !ok {
+		return
+	}
+
+	var req struct {
+		DefaultRole auth.Role `json:"default_role"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	// Ownership is only ever granted explicitly
+	if !auth.ValidRole(req.DefaultRole) || req.DefaultRole == auth.RoleOwner {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Default role must be editor, commenter, viewer or none"})
+		return
+	}
+
+	result, err := h.db.Exec(
+		"UPDATE documents SET default_role = $1 WHERE id = $2",
+		string(req.DefaultRole), documentID.String(),
+	)
+	if err != nil {
+		logger.Error("failed to update default role", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update access"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	}
+
+	logger.Info("default role updated", "document_id", documentID, "default_role", req.DefaultRole)
+	c.JSON(http.StatusOK, gin.H{"document_id": documentID, "default_role": req.DefaultRole})
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"fmt"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"storychain-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// Search limits: query length in bytes, and documents and changes returned.
+const (
+	maxSearchQuery         = 200
+	defaultSearchResults   = 20
+	maxSearchResults       = 50
+	maxSearchChangesPerDoc = 10
+)
+
+// Postgres marks matches in snippets with these; policy bans control
+// characters from content, so they cannot clash with real text.
+const (
+	matchStart = "\x02"
+	matchStop  = "\x03"
+)
+
+var headlineOptions = fmt.Sprintf("StartSel=%s, StopSel=%s, MaxFragments=2, MaxWords=20, MinWords=5", matchStart, matchStop)
+
+// highlight escapes a snippet for HTML and wraps its matches in <mark>.
+func highlight(snippet string) string {
+	snippet = html.EscapeString(snippet)
+	snippet = strings.ReplaceAll(snippet, matchStart, "<mark>")
+	return strings.ReplaceAll(snippet, matchStop, "</mark>")
+}
+
+// parseSearchTime reads an RFC 3339 time or a date. A date used as an upper
+// bound covers that whole day.
+func parseSearchTime(value string, upper bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err == nil && upper {
+		t = t.Add(24 * time.Hour)
+	}
+	return t, err
+}
+
+func (h *Handler) search(c *gin.Context) {
+	logger := h.logger(c)
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" || len(q) > maxSearchQuery {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("q must be between 1 and %d characters", maxSearchQuery)})
+		return
+	}
+	limit := defaultSearchResults
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxSearchResults {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxSearchResults)})
+			return
+		}
+		limit = n
+	}
+
+	// Author and date filters select changes; a document matches them when
+	// one of its matching changes does. Each condition has a %s for its value.
+	var conditions []string
+	var values []any
+	if author := strings.TrimSpace(c.Query("author")); author != "" {
+		if userID, err := uuid.Parse(author); err == nil {
+			conditions, values = append(conditions, "c.user_id = %s"), append(values, userID.String())
+		} else {
+			conditions, values = append(conditions, "lower(c.user_name) = lower(%s)"), append(values, author)
+		}
+	}
+	for _, bound := range []struct {
+		param string
+		op    string
+	}{{"from", ">="}, {"to", "<"}} {
+		value := c.Query(bound.param)
+		if value == "" {
+			continue
+		}
+		t, err := parseSearchTime(value, bound.op == "<")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": bound.param + " must be a date or an RFC 3339 time"})
+			return
+		}
+		conditions, values = append(conditions, "c.timestamp "+bound.op+" %s"), append(values, t)
+	}
+	// changeFilter appends the conditions to args and returns them as SQL
+	changeFilter := func(args *[]any) string {
+		var sql strings.Builder
+		for i, condition := range conditions {
+			*args = append(*args, values[i])
+			sql.WriteString(" AND " + fmt.Sprintf(condition, fmt.Sprintf("$%d", len(*args))))
+		}
+		return sql.String()
+	}
+
+	args := []any{q, headlineOptions}
+	filter := changeFilter(&args)
+
+	access := h.viewableFilter(c, &args)
+
+	query := `SELECT d.id, ts_headline('english', d.content, tsq, $2),
+		ts_rank(to_tsvector('english', d.content), tsq) AS rank, d.updated_at
+		FROM documents d, websearch_to_tsquery('english', $1) tsq
+		WHERE to_tsvector('english', d.content) @@ tsq` + access
+	if filter != "" {
+		query += ` AND EXISTS (SELECT 1 FROM changes c WHERE c.document_id = d.id
+			AND to_tsvector('english', c.content) @@ tsq AND c.reverted_by IS NULL` + filter + ")"
+	}
+	query += fmt.Sprintf(" ORDER BY rank DESC, d.updated_at DESC LIMIT %d", limit)
+
+	rows, err := h.db.Query(query, args...)
+	if err != nil {
+		logger.Error("failed to search documents", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+	results := []models.SearchResult{}
+	byDocument := map[uuid.UUID]int{}
+	var documentIDs []string
+	for rows.Next() {
+		var result models.SearchResult
+		if err := rows.Scan(&result.DocumentID, &result.Snippet, &result.Rank, &result.UpdatedAt); err != nil {
+			logger.Error("failed to scan search result", "error", err)
+			continue
+		}
+		result.Snippet = highlight(result.Snippet)
+		result.Changes = []models.SearchChange{}
+		byDocument[result.DocumentID] = len(results)
+		documentIDs = append(documentIDs, result.DocumentID.String())
+		results = append(results, result)
+	}
+	rows.Close()
+
+	if len(results) > 0 {
+		// Access is settled by the document list, so only the filters carry over
+		changeArgs := []any{q, headlineOptions, pq.Array(documentIDs)}
+		filter := changeFilter(&changeArgs)
+		changeQuery := fmt.Sprintf(`SELECT id, document_id, user_id, user_name, change_type, content, position, length, timestamp, revision, snippet
+			FROM (
+				SELECT c.id, c.document_id, c.user_id, c.user_name, c.change_type, c.content, c.position, c.length,
+					c.timestamp, COALESCE(c.revision, 0) AS revision, ts_headline('english', c.content, tsq, $2) AS snippet,
+					row_number() OVER (PARTITION BY c.document_id ORDER BY c.timestamp DESC) AS n
+				FROM changes c, websearch_to_tsquery('english', $1) tsq
+				WHERE c.document_id = ANY($3::uuid[]) AND to_tsvector('english', c.content) @@ tsq
+					AND c.reverted_by IS NULL%s
+			) matched WHERE n <= %d ORDER BY timestamp DESC`,
+			filter, maxSearchChangesPerDoc)
+		rows, err := h.db.Query(changeQuery, changeArgs...)
+		if err != nil {
+			logger.Error("failed to search changes", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+			return
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var match models.SearchChange
+			err := rows.Scan(
+				&match.ID, &match.DocumentID, &match.UserID, &match.UserName, &match.ChangeType, &match.Content,
+				&match.Position, &match.Length, &match.Timestamp, &match.Revision, &match.Snippet,
+			)
+			if err != nil {
+				logger.Error("failed to scan matching change", "error", err)
+				continue
+			}
+			match.Snippet = highlight(match.Snippet)
+			i := byDocument[match.DocumentID]
+			results[i].Changes = append(results[i].Changes, match)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"query": q, "results": results})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"storychain-backend/internal/auth"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// SessionHeader carries a session token on REST requests; websocket and
+// EventSource clients, which cannot set headers, pass it as the session
+// query parameter instead.
+const SessionHeader = "X-Session-Token"
+
+// sessionContextKey is where a request's verified session user is kept.
+const sessionContextKey = "session_user"
+
+// sessionToken returns the session token sent with a request, if any.
+func sessionToken(c *gin.Context) string {
+	if token := c.GetHeader(SessionHeader); token != "" {
+		return token
+	}
+	return c.Query("session")
+}
+
+// verifySession checks the request's session token, if it sends one, and
+// keeps its user for requestUser. A bad or expired token is answered with
+// a 401 so the client knows to start a new session rather than silently
+// acting as someone else.
+func (h *Handler) verifySession(c *gin.Context) bool {
+	token := sessionToken(c)
+	if token == "" {
+		return true
+	}
+	claims, err := h.sessions.VerifySession(token, time.Now())
+	if err != nil {
+		h.logger(c).Info("session rejected", "error", err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session", "code": "invalid_session"})
+		return false
+	}
+	c.Set(sessionContextKey, claims.UserID)
+	return true
+}
+
+// requireUser returns the caller's user ID, writing a 401 for anonymous
+// callers. Routes that record who did something use it, since uuid.Nil
+// stands for the system.
+func (h *Handler) requireUser(c *gin.Context) (uuid.UUID, bool) {
+	userID := requestUser(c)
+	if userID == uuid.Nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Start a session first", "code": "session_required"})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// createSession issues a session token. A caller with a valid session gets
+// a fresh token for the same user; anyone else gets a new user.
+func (h *Handler) createSession(c *gin.Context) {
+	userID := requestUser(c)
+	if userID == uuid.Nil {
+		userID = uuid.New()
+	}
+	expiresAt := time.Now().Add(h.cfg.Server.SessionTTL.Duration).UTC().Truncate(time.Second)
+	token := h.sessions.SignSession(auth.SessionClaims{UserID: userID, ExpiresAt: expiresAt.Unix()})
+
+	h.logger(c).Info("session issued", "user_id", userID)
+	c.JSON(http.StatusCreated, gin.H{"user_id": userID, "token": token, "expires_at": expiresAt})
+}
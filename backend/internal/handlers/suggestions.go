@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/metrics"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/policy"
+	"storychain-backend/internal/textops"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Suggestion statuses. Outdated suggestions targeted text that someone else
+// has since edited, so they can no longer be applied.
+const (
+	suggestionPending  = "pending"
+	suggestionAccepted = "accepted"
+	suggestionRejected = "rejected"
+	suggestionOutdated = "outdated"
+)
+
+const suggestionColumns = "id, document_id, author_id, author_name, change_type, content, position, length, base_revision, status, change_id, resolved_by, created_at, resolved_at"
+
+func scanSuggestion(row interface{ Scan(...any) error }) (models.Suggestion, error) {
+	var s models.Suggestion
+	var changeID, resolvedBy uuid.NullUUID
+	var resolvedAt sql.NullTime
+	err := row.Scan(
+		&s.ID, &s.DocumentID, &s.AuthorID, &s.AuthorName, &s.ChangeType, &s.Content, &s.Position,
+		&s.Length, &s.BaseRevision, &s.Status, &changeID, &resolvedBy, &s.CreatedAt, &resolvedAt,
+	)
+	if changeID.Valid {
+		s.ChangeID = &changeID.UUID
+	}
+	if resolvedBy.Valid {
+		s.ResolvedBy = &resolvedBy.UUID
+	}
+	if resolvedAt.Valid {
+		s.ResolvedAt = &resolvedAt.Time
+	}
+	return s, err
+}
+
+// change returns the edit a suggestion proposes, attributed to its author.
+func suggestedChange(s models.Suggestion) models.TextChange {
+	return models.TextChange{
+		DocumentID: s.DocumentID,
+		UserID:     s.AuthorID,
+		UserName:   s.AuthorName,
+		ChangeType: s.ChangeType,
+		Content:    s.Content,
+		Position:   s.Position,
+		Length:     s.Length,
+	}
+}
+
+// rebaseSuggestions moves a document's pending suggestions across the
+// normalized change that produced revision, marking those whose target text
+// it edited as outdated, and returns the ones it updated. A suggestion
+// anchored before the previous revision, such as one created while that
+// revision was committing, first catches up on the changes it missed. The
+// change's own row must already be saved for that.
+func (h *Handler) rebaseSuggestions(q queryer, documentID uuid.UUID, change models.TextChange, revision int64) ([]models.Suggestion, error) {
+	rows, err := q.Query(
+		"SELECT "+suggestionColumns+" FROM suggestions WHERE document_id = $1 AND status = $2 AND base_revision < $3",
+		documentID.String(), suggestionPending, revision,
+	)
+	if err != nil {
+		return nil, err
+	}
+	var pending []models.Suggestion
+	for rows.Next() {
+		s, err := scanSuggestion(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		pending = append(pending, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	updated := []models.Suggestion{}
+	for _, s := range pending {
+		var rebased models.TextChange
+		var ok bool
+		if s.BaseRevision == revision-1 {
+			rebased, ok = textops.Rebase(suggestedChange(s), change)
+		} else if rebased, ok, err = h.rebaseFrom(q, documentID, suggestedChange(s), s.BaseRevision); err != nil {
+			return nil, err
+		}
+
+		// Only move a suggestion from the revision it was read at
+		var result sql.Result
+		anchored := s.BaseRevision
+		s.BaseRevision = revision
+		if ok {
+			s.Position, s.Length = rebased.Position, rebased.Length
+			result, err = q.Exec(
+				`UPDATE suggestions SET position = $1, length = $2, base_revision = $3
+				WHERE id = $4 AND status = $5 AND base_revision = $6`,
+				s.Position, s.Length, revision, s.ID.String(), suggestionPending, anchored,
+			)
+		} else {
+			s.Status = suggestionOutdated
+			result, err = q.Exec(
+				`UPDATE suggestions SET status = $1, base_revision = $2, resolved_at = now()
+				WHERE id = $3 AND status = $4 AND base_revision = $5`,
+				suggestionOutdated, revision, s.ID.String(), suggestionPending, anchored,
+			)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			updated = append(updated, s)
+		}
+	}
+	return updated, nil
+}
+
+// broadcastSuggestion sends a suggestion event to the clients of a document.
+func (h *Handler) broadcastSuggestion(eventType string, documentID uuid.UUID, data any) {
+	message := models.WebSocketMessage{Type: eventType, Data: data}
+	if encoded, err := json.Marshal(message); err == nil {
+		h.hub.BroadcastToDocument(documentID, encoded)
+	}
+}
+
+func (h *Handler) getSuggestions(c *gin.Context) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, requestUser(c), auth.RoleViewer); !ok {
+		return
+	}
+
+	query := "SELECT " + suggestionColumns + " FROM suggestions WHERE document_id = $1"
+	args := []any{documentID.String()}
+	switch status := c.Query("status"); status {
+	case "":
+	case suggestionPending, suggestionAccepted, suggestionRejected, suggestionOutdated:
+		query += " AND status = $2"
+		args = append(args, status)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be pending, accepted, rejected or outdated"})
+		return
+	}
+	rows, err := h.db.Query(query+" ORDER BY created_at DESC LIMIT 200", args...)
+	if err != nil {
+		h.logger(c).Error("failed to query suggestions", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get suggestions"})
+		return
+	}
+	defer rows.Close()
+
+	suggestions := []models.Suggestion{}
+	for rows.Next() {
+		s, err := scanSuggestion(rows)
+		if err != nil {
+			h.logger(c).Error("failed to scan suggestion", "document_id", documentID, "error", err)
+			continue
+		}
+		suggestions = append(suggestions, s)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"document_id": documentID, "suggestions": suggestions})
+}
+
+func (h *Handler) createSuggestion(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	logger = logger.With("document_id", documentID)
+
+	var req struct {
+		models.TextChange
+		// BaseRevision is the revision the position refers to; the current one if omitted
+		BaseRevision *int64 `json:"base_revision"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	change := req.TextChange
+	change.DocumentID = documentID
+	userID, ok := h.requireUser(c)
+	if !ok {
+		return
+	}
+	change.UserID = userID
+	if _, ok := h.authorize(c, documentID, change.UserID, auth.RoleCommenter); !ok {
+		return
+	}
+	change.UserName = strings.TrimSpace(change.UserName)
+	if change.UserName == "" {
+		change.UserName = "Anonymous"
+	}
+
+	var content string
+	var revision int64
+	err = h.db.QueryRow(
+		"SELECT COALESCE(content, ''), revision FROM documents WHERE id = $1",
+		documentID.String(),
+	).Scan(&content, &revision)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to get document", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get document"})
+		return
+	}
+
+	if req.BaseRevision != nil && *req.BaseRevision != revision {
+		if *req.BaseRevision < 0 || *req.BaseRevision > revision {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown base revision"})
+			return
+		}
+		rebased, ok, err := h.rebaseFrom(h.db, documentID, change, *req.BaseRevision)
+		if err != nil {
+			logger.Error("failed to rebase suggestion", "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebase suggestion"})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusConflict, gin.H{
+				"error":    "The text this suggestion changes has been edited since",
+				"code":     "suggestion_conflict",
+				"revision": revision,
+			})
+			return
+		}
+		change = rebased
+	}
+
+	// Check now so authors hear about problems before an owner reviews it
+	if violation := policy.Check(h.cfg.Policy, change, content); violation != nil {
+		metrics.PolicyRejections.WithLabelValues(violation.Code).Inc()
+		c.JSON(http.StatusBadRequest, violation)
+		return
+	}
+	change = textops.Normalize(change, len(content))
+
+	s, err := scanSuggestion(h.db.QueryRow(
+		`INSERT INTO suggestions (document_id, author_id, author_name, change_type, content, position, length, base_revision)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING `+suggestionColumns,
+		documentID.String(), change.UserID.String(), change.UserName, change.ChangeType,
+		change.Content, change.Position, change.Length, revision,
+	))
+	if err != nil {
+		logger.Error("failed to create suggestion", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create suggestion"})
+		return
+	}
+
+	logger.Info("suggestion created", "suggestion_id", s.ID, "user_id", s.AuthorID)
+	h.broadcastSuggestion("suggestion_created", documentID, s)
+	c.JSON(http.StatusCreated, s)
+}
+
+// rebaseFrom moves a change made against an older revision across every
+// change committed since. It reports false if one of them conflicts.
+func (h *Handler) rebaseFrom(q queryer, documentID uuid.UUID, change models.TextChange, base int64) (models.TextChange, bool, error) {
+	rows, err := q.Query(
+		`SELECT change_type, content, position, length FROM changes
+		WHERE document_id = $1 AND revision > $2 ORDER BY revision`,
+		documentID.String(), base,
+	)
+	if err != nil {
+		return change, false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var committed models.TextChange
+		if err := rows.Scan(&committed.ChangeType, &committed.Content, &committed.Position, &committed.Length); err != nil {
+			return change, false, err
+		}
+		var ok bool
+		if change, ok = textops.Rebase(change, committed); !ok {
+			return change, false, nil
+		}
+	}
+	return change, true, rows.Err()
+}
+
+func (h *Handler) acceptSuggestion(c *gin.Context) {
+	h.resolveSuggestion(c, suggestionAccepted)
+}
+
+func (h *Handler) rejectSuggestion(c *gin.Context) {
+	h.resolveSuggestion(c, suggestionRejected)
+}
+
+// resolveSuggestion accepts or rejects a pending suggestion. Owners may do
+// either; authors may withdraw their own by rejecting it. Accepting applies
+// the change through the same checks as a direct edit.
+func (h *Handler) resolveSuggestion(c *gin.Context, status string) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	suggestionID, err := uuid.Parse(c.Param("suggestionId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid suggestion ID"})
+		return
+	}
+	logger = logger.With("document_id", documentID, "suggestion_id", suggestionID)
+
+	s, err := scanSuggestion(h.db.QueryRow(
+		"SELECT "+suggestionColumns+" FROM suggestions WHERE id = $1 AND document_id = $2",
+		suggestionID.String(), documentID.String(),
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Suggestion not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to get suggestion", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get suggestion"})
+		return
+	}
+
+	userID := requestUser(c)
+	required := auth.RoleOwner
+	if status == suggestionRejected && userID != uuid.Nil && userID == s.AuthorID {
+		required = auth.RoleCommenter
+	}
+	if _, ok := h.authorize(c, documentID, userID, required); !ok {
+		return
+	}
+
+	// Claim the suggestion first so it cannot be resolved twice
+	var resolvedBy *uuid.UUID
+	if userID != uuid.Nil {
+		resolvedBy = &userID
+	}
+	claimed, err := scanSuggestion(h.db.QueryRow(
+		`UPDATE suggestions SET status = $1, resolved_by = $2, resolved_at = now()
+		WHERE id = $3 AND status = $4 RETURNING `+suggestionColumns,
+		status, resolvedBy, suggestionID.String(), suggestionPending,
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":  "Suggestion is no longer pending",
+			"code":   "suggestion_not_pending",
+			"status": s.Status,
+		})
+		return
+	} else if err != nil {
+		logger.Error("failed to resolve suggestion", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve suggestion"})
+		return
+	}
+
+	if status == suggestionAccepted {
+		// Edits since the suggestion was last rebased are caught up with
+		// while the document is locked for the commit
+		changeID, ok := h.applyChange(c, logger, documentID, suggestedChange(claimed), &claimed.BaseRevision, false)
+		if !ok {
+			// applyChange has responded; leave the suggestion open for another try
+			if _, err := h.db.Exec(
+				"UPDATE suggestions SET status = $1, resolved_by = NULL, resolved_at = NULL WHERE id = $2",
+				suggestionPending, suggestionID.String(),
+			); err != nil {
+				logger.Error("failed to reopen suggestion", "error", err)
+			}
+			return
+		}
+		claimed.ChangeID = &changeID
+		if _, err := h.db.Exec(
+			"UPDATE suggestions SET change_id = $1 WHERE id = $2",
+			changeID.String(), suggestionID.String(),
+		); err != nil {
+			logger.Error("failed to link suggestion to change", "error", err)
+		}
+	}
+
+	logger.Info("suggestion resolved", "status", status, "user_id", userID)
+	h.broadcastSuggestion("suggestion_resolved", documentID, claimed)
+	c.JSON(http.StatusOK, claimed)
+}
@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// voterRoles are the explicit roles whose votes count. Anyone can start a
+// session, so votes from users on the document's default role would let
+// one person outvote everyone.
+var voterRoles = []string{string(auth.RoleCommenter), string(auth.RoleEditor), string(auth.RoleOwner)}
+
+// voteTally counts the votes on a change from users who still hold an
+// explicit voter role on its document.
+func (h *Handler) voteTally(documentID, changeID uuid.UUID) (models.VoteTally, error) {
+	tally := models.VoteTally{DocumentID: documentID, ChangeID: changeID}
+	var revertedBy uuid.NullUUID
+	err := h.db.QueryRow(
+		`SELECT
+			COUNT(*) FILTER (WHERE v.value > 0),
+			COUNT(*) FILTER (WHERE v.value < 0),
+			(SELECT reverted_by FROM changes WHERE id = $1)
+		FROM change_votes v JOIN document_roles r ON r.user_id = v.user_id AND r.document_id = $2
+		WHERE v.change_id = $1 AND r.role = ANY($3)`,
+		changeID.String(), documentID.String(), pq.Array(voterRoles),
+	).Scan(&tally.Upvotes, &tally.Downvotes, &revertedBy)
+	tally.Score = tally.Upvotes - tally.Downvotes
+	if revertedBy.Valid {
+		tally.RevertedBy = &revertedBy.UUID
+	}
+	return tally, err
+}
+
+func (h *Handler) voteChange(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	changeID, err := uuid.Parse(c.Param("changeId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid change ID"})
+		return
+	}
+	logger = logger.With("document_id", documentID, "change_id", changeID)
+
+	var req struct {
+		// Value is 1 to upvote, -1 to downvote and 0 to take a vote back
+		Value *int `json:"value"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	userID, ok := h.requireUser(c)
+	if !ok {
+		return
+	}
+	if req.Value == nil || *req.Value < -1 || *req.Value > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Value must be 1, -1 or 0"})
+		return
+	}
+	if _, ok := h.authorize(c, documentID, userID, auth.RoleCommenter); !ok {
+		return
+	}
+	var explicit bool
+	if err := h.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM document_roles WHERE document_id = $1 AND user_id = $2 AND role = ANY($3))",
+		documentID.String(), userID.String(), pq.Array(voterRoles),
+	).Scan(&explicit); err != nil {
+		logger.Error("failed to check voter role", "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check permissions"})
+		return
+	}
+	if !explicit {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Only users given a role on this document can vote",
+			"code":  "explicit_role_required",
+		})
+		return
+	}
+
+	var authorID uuid.UUID
+	var committedAt time.Time
+	err = h.db.QueryRow(
+		"SELECT user_id, timestamp FROM changes WHERE id = $1 AND document_id = $2",
+		changeID.String(), documentID.String(),
+	).Scan(&authorID, &committedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to get change", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get change"})
+		return
+	}
+	if authorID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot vote on your own change", "code": "own_change"})
+		return
+	}
+
+	if *req.Value == 0 {
+		_, err = h.db.Exec(
+			"DELETE FROM change_votes WHERE change_id = $1 AND user_id = $2",
+			changeID.String(), userID.String(),
+		)
+	} else {
+		_, err = h.db.Exec(
+			`INSERT INTO change_votes (change_id, user_id, value) VALUES ($1, $2, $3)
+			ON CONFLICT (change_id, user_id) DO UPDATE SET value = EXCLUDED.value, created_at = now()`,
+			changeID.String(), userID.String(), *req.Value,
+		)
+	}
+	if err != nil {
+		logger.Error("failed to save vote", "user_id", userID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save vote"})
+		return
+	}
+
+	tally, err := h.voteTally(documentID, changeID)
+	if err != nil {
+		logger.Error("failed to count votes", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count votes"})
+		return
+	}
+	logger.Info("vote recorded", "user_id", userID, "value", *req.Value, "score", tally.Score)
+	h.broadcastTally(tally)
+
+	threshold := h.cfg.Voting.RevertThreshold
+	if threshold > 0 && tally.RevertedBy == nil && -tally.Score >= threshold &&
+		time.Since(committedAt) <= h.cfg.Voting.Window.Duration {
+		h.background.Add(1)
+		go func() {
+			defer h.background.Done()
+			h.revertByVotes(logger, tally)
+		}()
+	}
+
+	c.JSON(http.StatusOK, tally)
+}
+
+// revertByVotes reverts a change voted below the threshold and broadcasts
+// its final tally.
+func (h *Handler) revertByVotes(logger *slog.Logger, tally models.VoteTally) {
+	revertID, err := h.revertChange(logger, tally.DocumentID, tally.ChangeID, "System (votes)")
+	switch {
+	case errors.Is(err, errAlreadyReverted):
+		return
+	case errors.Is(err, errRevertConflict):
+		logger.Info("vote revert skipped", "reason", err)
+		return
+	case err != nil:
+		logger.Error("failed to revert change after votes", "error", err)
+		return
+	}
+	logger.Info("votes reverted change", "revert_id", revertID, "score", tally.Score)
+	tally.RevertedBy = &revertID
+	h.broadcastTally(tally)
+}
+
+// broadcastTally sends a vote_tally event to the clients of a document.
+func (h *Handler) broadcastTally(tally models.VoteTally) {
+	message := models.WebSocketMessage{Type: "vote_tally", Data: tally}
+	if encoded, err := json.Marshal(message); err == nil {
+		h.hub.BroadcastToDocument(tally.DocumentID, encoded)
+	}
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"storychain-backend/internal/auth"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/webhooks"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// maxWebhooksPerDocument caps how many endpoints one document may notify.
+const maxWebhooksPerDocument = 10
+
+// Delivery log sizes, by default and at most.
+const (
+	defaultDeliveryLogSize = 50
+	maxDeliveryLogSize     = 200
+)
+
+// emit queues a webhook event for the document. Delivery happens in the
+// background, so failures here are only logged.
+func (h *Handler) emit(logger *slog.Logger, documentID uuid.UUID, event string, data any) {
+	if err := h.webhooks.Enqueue(documentID, event, data); err != nil {
+		logger.Error("failed to queue webhook event", "document_id", documentID, "event", event, "error", err)
+	}
+}
+
+// requireAdmin writes a 403 unless the request carries the admin token.
+func (h *Handler) requireAdmin(c *gin.Context) bool {
+	if auth.IsAdmin(c, h.cfg.Server.AdminToken) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": "This needs the admin token", "code": "admin_required"})
+	return false
+}
+
+// webhookRequest parses the document and webhook IDs of a webhook route
+// and checks the caller is an admin.
+func (h *Handler) webhookRequest(c *gin.Context) (uuid.UUID, uuid.UUID, bool) {
+	documentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return uuid.Nil, uuid.Nil, false
+	}
+	var webhookID uuid.UUID
+	if value := c.Param("webhookId"); value != "" {
+		if webhookID, err = uuid.Parse(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+			return uuid.Nil, uuid.Nil, false
+		}
+	}
+	return documentID, webhookID, h.requireAdmin(c)
+}
+
+func (h *Handler) getWebhooks(c *gin.Context) {
+	documentID, _, ok := h.webhookRequest(c)
+	if !ok {
+		return
+	}
+
+	rows, err := h.db.Query(
+		"SELECT id, document_id, url, events, created_at FROM webhooks WHERE document_id = $1 ORDER BY created_at",
+		documentID.String(),
+	)
+	if err != nil {
+		h.logger(c).Error("failed to query webhooks", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhooks"})
+		return
+	}
+	defer rows.Close()
+
+	hooks := []models.Webhook{}
+	for rows.Next() {
+		var hook models.Webhook
+		if err := rows.Scan(&hook.ID, &hook.DocumentID, &hook.URL, pq.Array(&hook.Events), &hook.CreatedAt); err != nil {
+			h.logger(c).Error("failed to scan webhook", "document_id", documentID, "error", err)
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"document_id": documentID, "events": webhooks.Events, "webhooks": hooks})
+}
+
+func (h *Handler) createWebhook(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, _, ok := h.webhookRequest(c)
+	if !ok {
+		return
+	}
+
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if !h.bindJSON(c, &req) {
+		return
+	}
+	target, err := url.Parse(req.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" || len(req.URL) > 2048 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "URL must be an absolute http(s) URL"})
+		return
+	}
+	if len(req.Events) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least one event is required", "events": webhooks.Events})
+		return
+	}
+	for _, event := range req.Events {
+		if !webhooks.ValidEvent(event) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown event %q", event), "events": webhooks.Events})
+			return
+		}
+	}
+	slices.Sort(req.Events)
+	req.Events = slices.Compact(req.Events)
+	if req.Secret == "" {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		req.Secret = hex.EncodeToString(secret)
+	} else if len(req.Secret) < 16 || len(req.Secret) > 128 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Secret must be between 16 and 128 characters"})
+		return
+	}
+
+	var count int
+	if err := h.db.QueryRow("SELECT COUNT(*) FROM webhooks WHERE document_id = $1", documentID.String()).Scan(&count); err != nil {
+		logger.Error("failed to count webhooks", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+	if count >= maxWebhooksPerDocument {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": fmt.Sprintf("A document may have at most %d webhooks", maxWebhooksPerDocument),
+			"code":  "too_many_webhooks",
+		})
+		return
+	}
+
+	hook := models.Webhook{ID: uuid.New(), DocumentID: documentID, URL: req.URL, Events: req.Events, Secret: req.Secret}
+	err = h.db.QueryRow(
+		`INSERT INTO webhooks (id, document_id, url, secret, events)
+		SELECT $1, id, $3, $4, $5 FROM documents WHERE id = $2
+		RETURNING created_at`,
+		hook.ID.String(), documentID.String(), hook.URL, hook.Secret, pq.Array(hook.Events),
+	).Scan(&hook.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found"})
+		return
+	} else if err != nil {
+		logger.Error("failed to create webhook", "document_id", documentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	logger.Info("webhook created", "document_id", documentID, "webhook_id", hook.ID, "events", strings.Join(hook.Events, ","))
+	c.JSON(http.StatusCreated, hook)
+}
+
+func (h *Handler) deleteWebhook(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, webhookID, ok := h.webhookRequest(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.db.Exec("DELETE FROM webhooks WHERE id = $1 AND document_id = $2", webhookID.String(), documentID.String())
+	if err != nil {
+		logger.Error("failed to delete webhook", "webhook_id", webhookID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	logger.Info("webhook deleted", "document_id", documentID, "webhook_id", webhookID)
+	c.Status(http.StatusNoContent)
+}
+
+func (h *Handler) getWebhookDeliveries(c *gin.Context) {
+	logger := h.logger(c)
+	documentID, webhookID, ok := h.webhookRequest(c)
+	if !ok {
+		return
+	}
+	status := c.Query("status")
+	switch status {
+	case "", "pending", "delivered", "failed":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be pending, delivered or failed"})
+		return
+	}
+	limit := defaultDeliveryLogSize
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveryLogSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLogSize)})
+			return
+		}
+		limit = n
+	}
+
+	var exists bool
+	if err := h.db.QueryRow(
+		"SELECT EXISTS (SELECT 1 FROM webhooks WHERE id = $1 AND document_id = $2)",
+		webhookID.String(), documentID.String(),
+	).Scan(&exists); err != nil {
+		logger.Error("failed to get webhook", "webhook_id", webhookID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deliveries"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	rows, err := h.db.Query(
+		`SELECT id, webhook_id, event, payload, status, attempts, next_attempt_at, response_status, last_error, created_at, finished_at
+		FROM webhook_deliveries WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+		ORDER BY created_at DESC LIMIT $3`,
+		webhookID.String(), status, limit,
+	)
+	if err != nil {
+		logger.Error("failed to query deliveries", "webhook_id", webhookID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get deliveries"})
+		return
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var payload []byte
+		var nextAttempt time.Time
+		var responseStatus sql.NullInt64
+		var finishedAt sql.NullTime
+		err := rows.Scan(
+			&delivery.ID, &delivery.WebhookID, &delivery.Event, &payload, &delivery.Status, &delivery.Attempts,
+			&nextAttempt, &responseStatus, &delivery.LastError, &delivery.CreatedAt, &finishedAt,
+		)
+		if err != nil {
+			logger.Error("failed to scan delivery", "webhook_id", webhookID, "error", err)
+			continue
+		}
+		delivery.Payload = payload
+		if delivery.Status == "pending" {
+			delivery.NextAttemptAt = &nextAttempt
+		}
+		if responseStatus.Valid {
+			n := int(responseStatus.Int64)
+			delivery.ResponseStatus = &n
+		}
+		if finishedAt.Valid {
+			delivery.FinishedAt = &finishedAt.Time
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"webhook_id": webhookID, "deliveries": deliveries})
+}
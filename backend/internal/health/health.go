@@ -0,0 +1,104 @@
+// Package health runs liveness and readiness checks and reports them over HTTP.
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type check struct {
+	name     string
+	critical bool
+	run      func(context.Context) error
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Name       string  `json:"name"`
+	Status     string  `json:"status"`
+	Critical   bool    `json:"critical"`
+	DurationMS float64 `json:"duration_ms"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// Checker runs a set of named checks concurrently, each bounded by timeout.
+type Checker struct {
+	checks  []check
+	timeout time.Duration
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout}
+}
+
+// Add registers a check. A failing critical check makes the whole report
+// fail; a failing non-critical one only degrades it.
+func (c *Checker) Add(name string, critical bool, run func(context.Context) error) {
+	c.checks = append(c.checks, check{name: name, critical: critical, run: run})
+}
+
+// Run executes every check and returns "ok", "degraded" or "fail" with the
+// individual results in registration order.
+func (c *Checker) Run(ctx context.Context) (string, []Result) {
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, chk := range c.checks {
+		wg.Add(1)
+		go func(i int, chk check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := chk.run(checkCtx)
+			result := Result{
+				Name:       chk.name,
+				Status:     "ok",
+				Critical:   chk.critical,
+				DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			}
+			if err != nil {
+				result.Status = "fail"
+				result.Error = err.Error()
+			}
+			results[i] = result
+		}(i, chk)
+	}
+	wg.Wait()
+
+	status := "ok"
+	for _, r := range results {
+		if r.Status == "ok" {
+			continue
+		}
+		if r.Critical {
+			return "fail", results
+		}
+		status = "degraded"
+	}
+	return status, results
+}
+
+// Handler responds 200 unless a critical check fails, in which case it
+// responds 503 so load balancers stop routing to this instance.
+func Handler(checker *Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		status, results := checker.Run(c.Request.Context())
+
+		code := http.StatusOK
+		if status == "fail" {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, gin.H{
+			"status":      status,
+			"checks":      results,
+			"duration_ms": float64(time.Since(start).Microseconds()) / 1000,
+			"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+}
@@ -0,0 +1,128 @@
+// Package metrics defines the Prometheus collectors exposed on /metrics.
+package metrics
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "storychain"
+
+// Registry holds every StoryChain collector plus the Go runtime and process ones.
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	WebSocketConnections = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Open websocket connections per document.",
+	}, []string{"document_id"})
+
+	SlowClientEvictions = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "websocket_slow_client_evictions_total",
+		Help:      "Websocket clients dropped because their send buffer was full.",
+	})
+
+	CooldownRejections = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cooldown_rejections_total",
+		Help:      "Edits ignored because the sender was still on cooldown.",
+	})
+
+	RateLimitRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Requests and websocket messages rejected by rate limiting.",
+	}, []string{"scope", "name"})
+
+	PolicyRejections = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "policy_rejections_total",
+		Help:      "Edits rejected by the content policy, by violation code.",
+	}, []string{"code"})
+
+	Edits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "edits_total",
+		Help:      "Committed document edits by change type.",
+	}, []string{"change_type"})
+
+	ModerationVerdicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "moderation_verdicts_total",
+		Help:      "Moderation results: clean, profane or error.",
+	}, []string{"verdict"})
+
+	ModerationDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "moderation_duration_seconds",
+		Help:      "Latency of calls to the moderation service.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2, 3, 5},
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPRequestDuration,
+		WebSocketConnections,
+		SlowClientEvictions,
+		CooldownRejections,
+		RateLimitRejections,
+		PolicyRejections,
+		Edits,
+		ModerationVerdicts,
+		ModerationDuration,
+	)
+}
+
+// RegisterDB exposes connection pool statistics from sql.DB.Stats().
+func RegisterDB(db *sql.DB) {
+	Registry.MustRegister(collectors.NewDBStatsCollector(db, namespace))
+}
+
+// RegisterBroadcastQueue exposes the number of messages waiting in the hub.
+func RegisterBroadcastQueue(depth func() int) {
+	Registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "broadcast_queue_depth",
+		Help:      "Messages queued for the websocket hub to broadcast.",
+	}, func() float64 { return float64(depth()) }))
+}
+
+// Middleware records request latency labelled by the matched route pattern,
+// so document IDs do not explode the label space.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		HTTPRequestDuration.
+			WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.HandlerFor(Registry, promhttp.HandlerOpts{}))
+}
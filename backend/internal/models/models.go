@@ -1,16 +1,59 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
 )
 
 type Document struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	Content   string    `json:"content" db:"content"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID        uuid.UUID     `json:"id" db:"id"`
+	Content   string        `json:"content" db:"content"`
+	CreatedAt time.Time     `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at" db:"updated_at"`
+	Chain     ChainSettings `json:"chain"`
+	Locks     []Lock        `json:"locks"`
+	// DefaultRole applies to users without an explicit role
+	DefaultRole string `json:"default_role"`
+	// Role is the requesting user's role
+	Role string `json:"role,omitempty"`
+	// Revision counts the changes committed to the document
+	Revision int64 `json:"revision"`
+}
+
+// DocumentRole is a role granted to one user on one document.
+type DocumentRole struct {
+	DocumentID uuid.UUID `json:"document_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	Role       string    `json:"role"`
+	// InviteID is the invite that granted the role, if one did
+	InviteID  *uuid.UUID `json:"invite_id,omitempty"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Lock protects the half-open range [Start, End) of a document from edits.
+type Lock struct {
+	ID         uuid.UUID `json:"id"`
+	DocumentID uuid.UUID `json:"document_id"`
+	Start      int       `json:"start"`
+	End        int       `json:"end"`
+	Label      string    `json:"label"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// LocksUpdate is broadcast whenever a document's locks are added, removed or moved.
+type LocksUpdate struct {
+	DocumentID uuid.UUID `json:"document_id"`
+	Locks      []Lock    `json:"locks"`
+}
+
+// ChainSettings turns a document into a story chain where participants take
+// turns adding one word or one sentence.
+type ChainSettings struct {
+	Enabled     bool   `json:"enabled"`
+	Unit        string `json:"unit"`
+	TurnSeconds int    `json:"turn_seconds"`
 }
 
 type User struct {
@@ -31,6 +74,21 @@ type Change struct {
 	Position   int       `json:"position" db:"position"`
 	Length     int       `json:"length" db:"length"`
 	Timestamp  time.Time `json:"timestamp" db:"timestamp"`
+	Revision   int64     `json:"revision" db:"revision"`
+	Upvotes    int       `json:"upvotes"`
+	Downvotes  int       `json:"downvotes"`
+	// RevertedBy is the change that undid this one by moderation or votes
+	RevertedBy *uuid.UUID `json:"reverted_by,omitempty" db:"reverted_by"`
+}
+
+// VoteTally is the vote count on a change, sent as a vote_tally event.
+type VoteTally struct {
+	DocumentID uuid.UUID  `json:"document_id"`
+	ChangeID   uuid.UUID  `json:"change_id"`
+	Upvotes    int        `json:"upvotes"`
+	Downvotes  int        `json:"downvotes"`
+	Score      int        `json:"score"`
+	RevertedBy *uuid.UUID `json:"reverted_by,omitempty"`
 }
 
 type UserCooldown struct {
@@ -54,7 +112,270 @@ type TextChange struct {
 }
 
 type UserPresence struct {
-	UserID   uuid.UUID `json:"user_id"`
-	UserName string    `json:"user_name"`
-	Status   string    `json:"status"`
-}
\ No newline at end of file
+	DocumentID uuid.UUID `json:"document_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	UserName   string    `json:"user_name"`
+	Status     string    `json:"status"`
+}
+
+type CursorState struct {
+	Position       int `json:"position"`
+	SelectionStart int `json:"selection_start"`
+	SelectionEnd   int `json:"selection_end"`
+}
+
+type CursorUpdate struct {
+	DocumentID uuid.UUID   `json:"document_id"`
+	UserID     uuid.UUID   `json:"user_id"`
+	UserName   string      `json:"user_name"`
+	Color      string      `json:"color"`
+	Cursor     CursorState `json:"cursor"`
+}
+
+type PresenceUser struct {
+	UserID     uuid.UUID    `json:"user_id"`
+	UserName   string       `json:"user_name"`
+	Color      string       `json:"color"`
+	Cursor     *CursorState `json:"cursor"`
+	Idle       bool         `json:"idle"`
+	LastActive time.Time    `json:"last_active"`
+}
+
+type PresenceSnapshot struct {
+	DocumentID uuid.UUID      `json:"document_id"`
+	Users      []PresenceUser `json:"users"`
+}
+
+type UserUpdate struct {
+	DocumentID uuid.UUID `json:"document_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	UserName   string    `json:"user_name"`
+	Color      string    `json:"color"`
+}
+
+type GoingAway struct {
+	Reason           string `json:"reason"`
+	ReconnectAfterMS int64  `json:"reconnect_after_ms"`
+}
+
+// TurnState is whose turn it is in a chain-mode document. UserID is nil while
+// nobody is connected to take a turn.
+type TurnState struct {
+	DocumentID   uuid.UUID   `json:"document_id"`
+	Active       bool        `json:"active"`
+	UserID       *uuid.UUID  `json:"user_id"`
+	UserName     string      `json:"user_name,omitempty"`
+	Deadline     *time.Time  `json:"deadline"`
+	TurnSeconds  int         `json:"turn_seconds"`
+	Participants []uuid.UUID `json:"participants"`
+}
+
+// Invite grants a role on a document to whoever presents its token.
+type Invite struct {
+	ID         uuid.UUID  `json:"id"`
+	DocumentID uuid.UUID  `json:"document_id"`
+	Role       string     `json:"role"`
+	CreatedBy  *uuid.UUID `json:"created_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	MaxUses    *int       `json:"max_uses"`
+	Uses       int        `json:"uses"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Comment discusses the range [Start, End) of a document. If the text it was
+// anchored to is deleted, the range collapses to the point of the deletion.
+type Comment struct {
+	ID         uuid.UUID `json:"id"`
+	DocumentID uuid.UUID `json:"document_id"`
+	Start      int       `json:"start"`
+	End        int       `json:"end"`
+	AuthorID   uuid.UUID `json:"author_id"`
+	AuthorName string    `json:"author_name"`
+	Body       string    `json:"body"`
+	Resolved   bool      `json:"resolved"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+type CommentAnchor struct {
+	ID    uuid.UUID `json:"id"`
+	Start int       `json:"start"`
+	End   int       `json:"end"`
+}
+
+// CommentAnchors is broadcast when edits move the anchors of comments.
+type CommentAnchors struct {
+	DocumentID uuid.UUID       `json:"document_id"`
+	Anchors    []CommentAnchor `json:"anchors"`
+}
+
+// CommentDeleted is broadcast when a comment is removed.
+type CommentDeleted struct {
+	DocumentID uuid.UUID `json:"document_id"`
+	ID         uuid.UUID `json:"id"`
+}
+
+// Suggestion is a change proposed by someone who may not edit directly.
+// While pending it is rebased so Position always refers to BaseRevision.
+type Suggestion struct {
+	ID           uuid.UUID  `json:"id"`
+	DocumentID   uuid.UUID  `json:"document_id"`
+	AuthorID     uuid.UUID  `json:"author_id"`
+	AuthorName   string     `json:"author_name"`
+	ChangeType   string     `json:"change_type"`
+	Content      string     `json:"content"`
+	Position     int        `json:"position"`
+	Length       int        `json:"length"`
+	BaseRevision int64      `json:"base_revision"`
+	Status       string     `json:"status"`
+	ChangeID     *uuid.UUID `json:"change_id"`
+	ResolvedBy   *uuid.UUID `json:"resolved_by"`
+	CreatedAt    time.Time  `json:"created_at"`
+	ResolvedAt   *time.Time `json:"resolved_at"`
+}
+
+// SuggestionsRebased is broadcast when edits move pending suggestions.
+type SuggestionsRebased struct {
+	DocumentID  uuid.UUID    `json:"document_id"`
+	Revision    int64        `json:"revision"`
+	Suggestions []Suggestion `json:"suggestions"`
+}
+
+// SearchResult is a document matching a search, with the changes that
+// introduced the matched text.
+type SearchResult struct {
+	DocumentID uuid.UUID      `json:"document_id"`
+	Snippet    string         `json:"snippet"`
+	Rank       float64        `json:"rank"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	Changes    []SearchChange `json:"changes"`
+}
+
+// SearchChange is a change whose content matches a search.
+type SearchChange struct {
+	Change
+	Snippet string `json:"snippet"`
+}
+
+// BlameSpan is a range of a document written by one change. Text that no
+// recorded change explains, such as the initial content, has no change.
+type BlameSpan struct {
+	Start     int        `json:"start"`
+	End       int        `json:"end"`
+	ChangeID  *uuid.UUID `json:"change_id"`
+	UserID    uuid.UUID  `json:"user_id"`
+	UserName  string     `json:"user_name"`
+	Timestamp *time.Time `json:"timestamp"`
+}
+
+// ContributionCounts totals a user's committed changes.
+type ContributionCounts struct {
+	Edits             int        `json:"edits"`
+	Inserts           int        `json:"inserts"`
+	Deletes           int        `json:"deletes"`
+	Replaces          int        `json:"replaces"`
+	CharsAdded        int64      `json:"chars_added"`
+	CharsRemoved      int64      `json:"chars_removed"`
+	Reverted          int        `json:"reverted"`
+	FirstContribution *time.Time `json:"first_contribution"`
+	LastContribution  *time.Time `json:"last_contribution"`
+}
+
+// Add folds other into c.
+func (c *ContributionCounts) Add(other ContributionCounts) {
+	c.Edits += other.Edits
+	c.Inserts += other.Inserts
+	c.Deletes += other.Deletes
+	c.Replaces += other.Replaces
+	c.CharsAdded += other.CharsAdded
+	c.CharsRemoved += other.CharsRemoved
+	c.Reverted += other.Reverted
+	if other.FirstContribution != nil && (c.FirstContribution == nil || other.FirstContribution.Before(*c.FirstContribution)) {
+		c.FirstContribution = other.FirstContribution
+	}
+	if other.LastContribution != nil && (c.LastContribution == nil || other.LastContribution.After(*c.LastContribution)) {
+		c.LastContribution = other.LastContribution
+	}
+}
+
+// DocumentContribution is what one user has contributed to one document.
+type DocumentContribution struct {
+	DocumentID uuid.UUID `json:"document_id"`
+	UserID     uuid.UUID `json:"user_id"`
+	UserName   string    `json:"user_name"`
+	ContributionCounts
+}
+
+// LeaderboardEntry ranks a contributor within a document.
+type LeaderboardEntry struct {
+	Rank int `json:"rank"`
+	DocumentContribution
+}
+
+// UserStats is a user's contributions across the documents the caller can
+// see, with their streaks of consecutive active days (UTC).
+type UserStats struct {
+	UserID        uuid.UUID `json:"user_id"`
+	UserName      string    `json:"user_name"`
+	ActiveDays    int       `json:"active_days"`
+	CurrentStreak int       `json:"current_streak"`
+	LongestStreak int       `json:"longest_streak"`
+	ContributionCounts
+	Documents []DocumentContribution `json:"documents"`
+}
+
+// Stats are the site-wide figures sent as stats_update.
+type Stats struct {
+	TotalEdits  int `json:"total_edits"`
+	UniqueUsers int `json:"unique_users"`
+	OnlineCount int `json:"online_count"`
+}
+
+// ActivityBucket is the activity in one hour or day of a time series.
+type ActivityBucket struct {
+	Start         time.Time `json:"start"`
+	Edits         int       `json:"edits"`
+	UniqueEditors int       `json:"unique_editors"`
+	PeakUsers     int       `json:"peak_users"`
+}
+
+// Webhook is an endpoint notified of a document's events. The secret is
+// only returned when the webhook is created.
+type Webhook struct {
+	ID         uuid.UUID `json:"id"`
+	DocumentID uuid.UUID `json:"document_id"`
+	URL        string    `json:"url"`
+	Events     []string  `json:"events"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookDelivery is one event sent, or still to be sent, to a webhook.
+type WebhookDelivery struct {
+	ID             uuid.UUID       `json:"id"`
+	WebhookID      uuid.UUID       `json:"webhook_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"`
+	ResponseStatus *int            `json:"response_status"`
+	LastError      string          `json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	FinishedAt     *time.Time      `json:"finished_at"`
+}
+
+// APIKey lets a bot use the API as its own user. The key itself is only
+// returned when it is created.
+type APIKey struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	BotUserID  uuid.UUID  `json:"bot_user_id"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	RateLimit  int        `json:"rate_limit"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+}
@@ -0,0 +1,154 @@
+// Package moderation talks to the external profanity service.
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type Client struct {
+	endpoint string
+	http     *http.Client
+}
+
+func NewClient(endpoint string, timeout time.Duration) *Client {
+	return &Client{
+		endpoint: endpoint,
+		http:     &http.Client{Timeout: timeout},
+	}
+}
+
+// Ping reports whether the service answers at all; any HTTP response,
+// whatever its status, counts as reachable.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Check asks the profanity service whether message is profane. Responses
+// it cannot interpret count as clean so moderation never blocks editing.
+func (c *Client) Check(ctx context.Context, logger *slog.Logger, message string) (bool, error) {
+	payload := map[string]string{"message": message}
+	b, _ := json.Marshal(payload)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.endpoint, bytes.NewReader(b))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	logger.Debug("profanity request sending", "bytes", len(b))
+	resp, err := c.http.Do(req)
+	if err != nil {
+		logger.Debug("profanity request failed", "error", err)
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	logger.Debug("profanity response", "status", resp.StatusCode, "body", trimForLog(string(body), 200))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		// Treat non-2xx as non-blocking
+		return false, nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		logger.Debug("profanity response parse failed", "error", err)
+		return false, nil
+	}
+
+	// Interpret common shapes
+	if b, ok := data.(bool); ok {
+		logger.Debug("profanity verdict", "source", "boolean", "profane", b)
+		return b, nil
+	}
+	if m, ok := data.(map[string]interface{}); ok {
+		// direct boolean-like keys
+		for key, v := range m {
+			lk := strings.ToLower(strings.ReplaceAll(key, "_", ""))
+			if lk == "isprofanity" || lk == "isprofane" || lk == "profanity" || lk == "flagged" || lk == "containsprofanity" {
+				if vb, ok := v.(bool); ok {
+					logger.Debug("profanity verdict", "source", key, "profane", vb)
+					return vb, nil
+				}
+				if vs, ok := v.(string); ok {
+					b := strings.EqualFold(vs, "true") || vs == "1"
+					logger.Debug("profanity verdict", "source", key, "value", vs, "profane", b)
+					return b, nil
+				}
+				if vn, ok := v.(float64); ok {
+					b := vn >= 0.5
+					logger.Debug("profanity verdict", "source", key, "score", vn, "profane", b)
+					return b, nil
+				}
+			}
+		}
+		// flaggedFor presence
+		if v, ok := m["flaggedFor"]; ok {
+			if s, ok := v.(string); ok && strings.TrimSpace(s) != "" {
+				logger.Debug("profanity verdict", "source", "flaggedFor", "value", s, "profane", true)
+				return true, nil
+			}
+		}
+		// label/result-like
+		for _, k := range []string{"label", "result", "prediction"} {
+			if v, ok := m[k]; ok {
+				if s, ok := v.(string); ok && strings.Contains(strings.ToLower(s), "profan") {
+					logger.Debug("profanity verdict", "source", k, "value", s, "profane", true)
+					return true, nil
+				}
+			}
+		}
+		// scalar score at top level
+		if v, ok := m["score"]; ok {
+			switch t := v.(type) {
+			case float64:
+				if t > 0.8 {
+					logger.Debug("profanity verdict", "source", "score", "score", t, "profane", true)
+					return true, nil
+				}
+			}
+		}
+		// scores map
+		if v, ok := m["scores"]; ok {
+			if scores, ok := v.(map[string]interface{}); ok {
+				for k, val := range scores {
+					if strings.Contains(strings.ToLower(k), "profan") {
+						switch t := val.(type) {
+						case float64:
+							if t > 0.8 {
+								logger.Debug("profanity verdict", "source", k, "score", t, "profane", true)
+								return true, nil
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return false, nil
+}
+
+func trimForLog(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "…"
+}
@@ -0,0 +1,122 @@
+// Package origin decides which browser origins may call the API, for both
+// CORS responses and websocket upgrades.
+package origin
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Policy matches origins against exact entries such as
+// "https://storychain.app" and wildcard patterns such as
+// "https://*.vercel.app". The single entry "*" allows every origin.
+type Policy struct {
+	allowAll bool
+	exact    map[string]bool
+	patterns []string
+}
+
+// NewPolicy validates the entries and builds a policy from them.
+func NewPolicy(origins []string) (*Policy, error) {
+	p := &Policy{exact: make(map[string]bool)}
+	for _, entry := range origins {
+		entry = normalize(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			p.allowAll = true
+			continue
+		}
+		if err := validate(entry); err != nil {
+			return nil, err
+		}
+		if strings.Contains(entry, "*") {
+			p.patterns = append(p.patterns, entry)
+		} else {
+			p.exact[entry] = true
+		}
+	}
+	return p, nil
+}
+
+func validate(entry string) error {
+	scheme, host, ok := strings.Cut(entry, "://")
+	if !ok || (scheme != "http" && scheme != "https") || host == "" {
+		return fmt.Errorf("invalid origin %q: want scheme://host[:port]", entry)
+	}
+	if strings.ContainsAny(host, "/?#@") {
+		return fmt.Errorf("invalid origin %q: must not contain a path, query or credentials", entry)
+	}
+	if _, err := path.Match(entry, ""); err != nil {
+		return fmt.Errorf("invalid origin pattern %q: %w", entry, err)
+	}
+	return nil
+}
+
+func normalize(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(origin)), "/")
+}
+
+// Allowed reports whether a browser origin matches the policy.
+func (p *Policy) Allowed(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	origin = normalize(origin)
+	if origin == "" {
+		return false
+	}
+	if p.exact[origin] {
+		return true
+	}
+	for _, pattern := range p.patterns {
+		// "*" does not cross "/", so a pattern cannot match into a path
+		if ok, _ := path.Match(pattern, origin); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrigin is a websocket.Upgrader CheckOrigin function. Requests
+// without an Origin header come from non-browser clients, which are not
+// exposed to cross-site hijacking, so they are let through.
+func (p *Policy) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	return p.Allowed(origin)
+}
+
+// CORS sets CORS headers for allowed origins and answers preflight requests.
+// exposedHeaders lists the response headers browser code may read.
+func (p *Policy) CORS(allowedHeaders, exposedHeaders []string) gin.HandlerFunc {
+	allowHeaders := strings.Join(allowedHeaders, ", ")
+	exposeHeaders := strings.Join(exposedHeaders, ", ")
+	return func(c *gin.Context) {
+		c.Header("Vary", "Origin")
+		if origin := c.GetHeader("Origin"); origin != "" && p.Allowed(origin) {
+			// Echo the origin: credentials are not allowed with a literal "*"
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+			if exposeHeaders != "" {
+				c.Header("Access-Control-Expose-Headers", exposeHeaders)
+			}
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if c.Request.Method == "OPTIONS" {
+			c.AbortWithStatus(204)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,163 @@
+// Package policy checks edits against the configured content rules before
+// they are applied.
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"storychain-backend/internal/config"
+	"storychain-backend/internal/models"
+	"storychain-backend/internal/textops"
+)
+
+// Violation codes returned to clients so they can show a specific message.
+const (
+	CodeInvalidChangeType = "invalid_change_type"
+	CodeInvalidPosition   = "invalid_position"
+	CodeInsertTooLong     = "insert_too_long"
+	CodeDeleteTooLong     = "delete_too_long"
+	CodeTooManyWords      = "too_many_words"
+	CodeDocumentTooLarge  = "document_too_large"
+	CodeBannedCharacters  = "banned_characters"
+	CodeLinksNotAllowed   = "links_not_allowed"
+	CodeChainInsertOnly   = "chain_insert_only"
+	CodeChainOneWord      = "chain_one_word"
+	CodeChainOneSentence  = "chain_one_sentence"
+)
+
+// Units a chain-mode document accepts per turn.
+const (
+	ChainUnitWord     = "word"
+	ChainUnitSentence = "sentence"
+)
+
+// Violation describes the first rule an edit broke. Limit is the configured
+// bound for size rules and zero otherwise.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"error"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+func (v *Violation) Error() string {
+	return v.Message
+}
+
+var (
+	urlPattern   = regexp.MustCompile(`(?i)https?://[^\s<>"{}|\\^` + "`" + `\[\]]+|www\.[^\s<>"{}|\\^` + "`" + `\[\]]+|ftp://[^\s<>"{}|\\^` + "`" + `\[\]]+`)
+	emailPattern = regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Z|a-z]{2,}\b`)
+)
+
+// ContainsLinks reports whether content has a URL or email address in it.
+func ContainsLinks(content string) bool {
+	return urlPattern.MatchString(content) || emailPattern.MatchString(content)
+}
+
+// characterClasses maps the names accepted in policy.banned_characters to
+// the runes they cover.
+var characterClasses = map[string]func(rune) bool{
+	"control": func(r rune) bool {
+		return unicode.IsControl(r) && r != '\n' && r != '\t' && r != '\r'
+	},
+	"zero_width": func(r rune) bool {
+		return r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\u2060' || r == '\ufeff'
+	},
+	"bidi": func(r rune) bool {
+		return unicode.Is(unicode.Bidi_Control, r)
+	},
+	"private_use": func(r rune) bool {
+		return unicode.Is(unicode.Co, r)
+	},
+	"emoji": func(r rune) bool {
+		return (r >= 0x1f300 && r <= 0x1faff) || (r >= 0x2600 && r <= 0x27bf) || r == '\ufe0f'
+	},
+}
+
+// Check returns the first rule change breaks when applied to current, or nil
+// if the edit is allowed.
+func Check(rules config.PolicyConfig, change models.TextChange, current string) *Violation {
+	switch change.ChangeType {
+	case "insert", "delete", "replace":
+	default:
+		return &Violation{Code: CodeInvalidChangeType, Message: "Change type must be insert, delete or replace"}
+	}
+	if change.Position < 0 || change.Length < 0 {
+		return &Violation{Code: CodeInvalidPosition, Message: "Position and length must not be negative"}
+	}
+
+	// Limits count characters; positions and lengths are in bytes
+	applied := textops.Normalize(change, len(current))
+	inserted := utf8.RuneCountInString(applied.Content)
+	removed := utf8.RuneCountInString(textops.Removed(current, applied))
+	if rules.MaxInsertLength > 0 && inserted > rules.MaxInsertLength {
+		return &Violation{
+			Code:    CodeInsertTooLong,
+			Message: fmt.Sprintf("Edits may add at most %d characters", rules.MaxInsertLength),
+			Limit:   rules.MaxInsertLength,
+		}
+	}
+	if rules.MaxDeleteLength > 0 && removed > rules.MaxDeleteLength {
+		return &Violation{
+			Code:    CodeDeleteTooLong,
+			Message: fmt.Sprintf("Edits may remove at most %d characters", rules.MaxDeleteLength),
+			Limit:   rules.MaxDeleteLength,
+		}
+	}
+	if rules.MaxWordsPerEdit > 0 && len(strings.Fields(applied.Content)) > rules.MaxWordsPerEdit {
+		return &Violation{
+			Code:    CodeTooManyWords,
+			Message: fmt.Sprintf("Edits may add at most %d words", rules.MaxWordsPerEdit),
+			Limit:   rules.MaxWordsPerEdit,
+		}
+	}
+	// Edits that shrink an oversized document are still allowed
+	currentSize := utf8.RuneCountInString(current)
+	size := currentSize - removed + inserted
+	if rules.MaxDocumentSize > 0 && size > rules.MaxDocumentSize && size > currentSize {
+		return &Violation{
+			Code:    CodeDocumentTooLarge,
+			Message: fmt.Sprintf("Documents may be at most %d characters", rules.MaxDocumentSize),
+			Limit:   rules.MaxDocumentSize,
+		}
+	}
+	for _, class := range rules.BannedCharacters {
+		banned, ok := characterClasses[class]
+		if ok && strings.IndexFunc(applied.Content, banned) >= 0 {
+			return &Violation{
+				Code:    CodeBannedCharacters,
+				Message: fmt.Sprintf("Content contains characters that are not allowed (%s)", class),
+			}
+		}
+	}
+	if rules.BlockLinks && ContainsLinks(applied.Content) {
+		return &Violation{Code: CodeLinksNotAllowed, Message: "Links are not allowed in content"}
+	}
+	return nil
+}
+
+// CheckChain applies the story chain rules: each turn inserts exactly one
+// word or one sentence, depending on the document's unit.
+func CheckChain(settings models.ChainSettings, change models.TextChange) *Violation {
+	if change.ChangeType != "insert" {
+		return &Violation{Code: CodeChainInsertOnly, Message: "Story chain documents only accept insertions"}
+	}
+
+	text := strings.TrimSpace(change.Content)
+	switch settings.Unit {
+	case ChainUnitSentence:
+		// Closing punctuation may repeat ("?!"), but none may appear earlier
+		body := strings.TrimRight(text, `.!?"')`)
+		if body == "" || strings.ContainsAny(body, ".!?") {
+			return &Violation{Code: CodeChainOneSentence, Message: "Add exactly one sentence per turn", Limit: 1}
+		}
+	default:
+		if len(strings.Fields(text)) != 1 {
+			return &Violation{Code: CodeChainOneWord, Message: "Add exactly one word per turn", Limit: 1}
+		}
+	}
+	return nil
+}
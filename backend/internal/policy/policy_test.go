@@ -0,0 +1,123 @@
+package policy
+
+import (
+	"testing"
+
+	"storychain-backend/internal/config"
+	"storychain-backend/internal/models"
+)
+
+func ins(pos int, text string) models.TextChange {
+	return models.TextChange{ChangeType: "insert", Position: pos, Content: text}
+}
+
+func del(pos, length int) models.TextChange {
+	return models.TextChange{ChangeType: "delete", Position: pos, Length: length}
+}
+
+func rep(pos, length int, text string) models.TextChange {
+	return models.TextChange{ChangeType: "replace", Position: pos, Length: length, Content: text}
+}
+
+func TestCheck(t *testing.T) {
+	// 11 characters in 13 bytes
+	const current = "héllo wörld"
+	tests := []struct {
+		name   string
+		rules  config.PolicyConfig
+		change models.TextChange
+		code   string
+		limit  int
+	}{
+		{"unknown change type", config.PolicyConfig{}, models.TextChange{ChangeType: "move"}, CodeInvalidChangeType, 0},
+		{"negative position", config.PolicyConfig{}, del(-1, 2), CodeInvalidPosition, 0},
+		{"negative length", config.PolicyConfig{}, del(1, -2), CodeInvalidPosition, 0},
+		{"no limits", config.PolicyConfig{}, ins(0, "anything at all"), "", 0},
+
+		{"insert at the limit", config.PolicyConfig{MaxInsertLength: 5}, ins(0, "ééééé"), "", 0},
+		{"insert over the limit", config.PolicyConfig{MaxInsertLength: 5}, ins(0, "éééééé"), CodeInsertTooLong, 5},
+		{"replace checks what it adds", config.PolicyConfig{MaxInsertLength: 2}, rep(0, 1, "abc"), CodeInsertTooLong, 2},
+
+		{"delete at the limit", config.PolicyConfig{MaxDeleteLength: 3}, del(0, 4), "", 0},
+		{"delete over the limit", config.PolicyConfig{MaxDeleteLength: 3}, del(0, 5), CodeDeleteTooLong, 3},
+		{"delete past the end counts what is there", config.PolicyConfig{MaxDeleteLength: 4}, del(8, 100), "", 0},
+		{"replace checks what it removes", config.PolicyConfig{MaxDeleteLength: 3}, rep(0, 5, "x"), CodeDeleteTooLong, 3},
+
+		{"words at the limit", config.PolicyConfig{MaxWordsPerEdit: 2}, ins(0, " two  words "), "", 0},
+		{"words over the limit", config.PolicyConfig{MaxWordsPerEdit: 2}, ins(0, "three words here"), CodeTooManyWords, 2},
+
+		{"document at the limit", config.PolicyConfig{MaxDocumentSize: 12}, ins(0, "é"), "", 0},
+		{"document over the limit", config.PolicyConfig{MaxDocumentSize: 12}, ins(0, "éé"), CodeDocumentTooLarge, 12},
+		{"replace that fits the limit", config.PolicyConfig{MaxDocumentSize: 12}, rep(0, 1, "ab"), "", 0},
+		{"oversized document may shrink", config.PolicyConfig{MaxDocumentSize: 5}, del(0, 1), "", 0},
+		{"oversized document may keep its size", config.PolicyConfig{MaxDocumentSize: 5}, rep(0, 1, "x"), "", 0},
+		{"oversized document may not grow", config.PolicyConfig{MaxDocumentSize: 5}, ins(0, "x"), CodeDocumentTooLarge, 5},
+
+		{"banned zero width", config.PolicyConfig{BannedCharacters: []string{"zero_width"}}, ins(0, "a\u200bb"), CodeBannedCharacters, 0},
+		{"banned bidi", config.PolicyConfig{BannedCharacters: []string{"zero_width", "bidi"}}, ins(0, "a\u202eb"), CodeBannedCharacters, 0},
+		{"banned control", config.PolicyConfig{BannedCharacters: []string{"control"}}, ins(0, "a\x07b"), CodeBannedCharacters, 0},
+		{"control allows newlines and tabs", config.PolicyConfig{BannedCharacters: []string{"control"}}, ins(0, "a\n\tb\r\n"), "", 0},
+		{"class not banned", config.PolicyConfig{BannedCharacters: []string{"emoji"}}, ins(0, "a\u200bb"), "", 0},
+		{"unknown class is ignored", config.PolicyConfig{BannedCharacters: []string{"vowels"}}, ins(0, "aeiou"), "", 0},
+
+		{"url blocked", config.PolicyConfig{BlockLinks: true}, ins(0, "see https://example.com"), CodeLinksNotAllowed, 0},
+		{"www blocked", config.PolicyConfig{BlockLinks: true}, ins(0, "www.example.com"), CodeLinksNotAllowed, 0},
+		{"email blocked", config.PolicyConfig{BlockLinks: true}, ins(0, "mail ann@example.org"), CodeLinksNotAllowed, 0},
+		{"plain text allowed", config.PolicyConfig{BlockLinks: true}, ins(0, "example dot com"), "", 0},
+		{"links allowed", config.PolicyConfig{}, ins(0, "https://example.com"), "", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := Check(tt.rules, tt.change, current)
+			if tt.code == "" {
+				if v != nil {
+					t.Fatalf("Check = %s (%s), want no violation", v.Code, v.Message)
+				}
+				return
+			}
+			if v == nil {
+				t.Fatalf("Check = nil, want %s", tt.code)
+			}
+			if v.Code != tt.code || v.Limit != tt.limit {
+				t.Errorf("Check = %s limit %d, want %s limit %d", v.Code, v.Limit, tt.code, tt.limit)
+			}
+		})
+	}
+}
+
+func TestCheckChain(t *testing.T) {
+	word := models.ChainSettings{Enabled: true, Unit: ChainUnitWord}
+	sentence := models.ChainSettings{Enabled: true, Unit: ChainUnitSentence}
+	tests := []struct {
+		name     string
+		settings models.ChainSettings
+		change   models.TextChange
+		code     string
+	}{
+		{"one word", word, ins(0, "hello"), ""},
+		{"one word with spaces", word, ins(0, " hello "), ""},
+		{"two words", word, ins(0, "hello there"), CodeChainOneWord},
+		{"no word", word, ins(0, "  "), CodeChainOneWord},
+		{"unit defaults to words", models.ChainSettings{Enabled: true}, ins(0, "hello there"), CodeChainOneWord},
+		{"delete", word, del(0, 3), CodeChainInsertOnly},
+		{"replace", sentence, rep(0, 3, "Hi."), CodeChainInsertOnly},
+
+		{"one sentence", sentence, ins(0, "It rained all day."), ""},
+		{"repeated closing punctuation", sentence, ins(0, "Really?!"), ""},
+		{"closing quote", sentence, ins(0, ` He said "no."`), ""},
+		{"sentence without punctuation", sentence, ins(0, "and then"), ""},
+		{"two sentences", sentence, ins(0, "It rained. We stayed in."), CodeChainOneSentence},
+		{"only punctuation", sentence, ins(0, "..."), CodeChainOneSentence},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := CheckChain(tt.settings, tt.change)
+			switch {
+			case tt.code == "" && v != nil:
+				t.Errorf("CheckChain = %s, want no violation", v.Code)
+			case tt.code != "" && (v == nil || v.Code != tt.code):
+				t.Errorf("CheckChain = %v, want %s", v, tt.code)
+			}
+		})
+	}
+}
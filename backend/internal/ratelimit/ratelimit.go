@@ -0,0 +1,126 @@
+// Package ratelimit applies token-bucket limits per client IP and per user
+// to REST route groups and websocket message types.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"storychain-backend/internal/config"
+	"storychain-backend/internal/logging"
+	"storychain-backend/internal/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// UserKey is the gin context key under which middleware earlier in the chain
+// leaves the caller's verified user ID, for per-user limits.
+const UserKey = "rate_limit_user"
+
+// Decision is the outcome of taking a token from a bucket.
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets. Take removes one token from the bucket at key,
+// refilling it first according to rule.
+type Store interface {
+	Take(ctx context.Context, key string, rule config.RateRule) (Decision, error)
+}
+
+// Limiter applies the configured rules using a Store.
+type Limiter struct {
+	cfg   config.RateLimitConfig
+	store Store
+	log   *slog.Logger
+}
+
+func NewLimiter(cfg config.RateLimitConfig, store Store, logger *slog.Logger) *Limiter {
+	return &Limiter{cfg: cfg, store: store, log: logger}
+}
+
+// refillRate is the number of tokens a rule adds per second.
+func refillRate(rule config.RateRule) float64 {
+	return float64(rule.Requests) / rule.Per.Seconds()
+}
+
+// take checks every key against rule and denies if any bucket is empty.
+// Store errors fail open so an outage of the shared store never blocks editing.
+func (l *Limiter) take(ctx context.Context, rule config.RateRule, keys ...string) Decision {
+	result := Decision{Allowed: true}
+	for _, key := range keys {
+		decision, err := l.store.Take(ctx, key, rule)
+		if err != nil {
+			l.log.Warn("rate limit store failed, allowing request", "key", key, "error", err)
+			continue
+		}
+		if !decision.Allowed {
+			result.Allowed = false
+			result.RetryAfter = max(result.RetryAfter, decision.RetryAfter)
+		}
+	}
+	return result
+}
+
+// Take takes a token from the bucket at key under rule, whatever the
+// configured rules, for limits kept elsewhere such as per API key. Store
+// errors fail open.
+func (l *Limiter) Take(ctx context.Context, key string, rule config.RateRule) Decision {
+	return l.take(ctx, rule, key)
+}
+
+// Middleware limits a REST route group by client IP and, when the caller's
+// user is known under UserKey, by user. Groups without a rule are not limited.
+func (l *Limiter) Middleware(group string) gin.HandlerFunc {
+	rule, ok := l.cfg.Routes[group]
+	if !l.cfg.Enabled || !ok {
+		return func(c *gin.Context) { c.Next() }
+	}
+
+	return func(c *gin.Context) {
+		keys := []string{fmt.Sprintf("route:%s:ip:%s", group, c.ClientIP())}
+		if userID, ok := c.Value(UserKey).(uuid.UUID); ok && userID != uuid.Nil {
+			keys = append(keys, fmt.Sprintf("route:%s:user:%s", group, userID))
+		}
+
+		decision := l.take(c.Request.Context(), rule, keys...)
+		if decision.Allowed {
+			c.Next()
+			return
+		}
+
+		metrics.RateLimitRejections.WithLabelValues("route", group).Inc()
+		logging.FromContext(c, l.log).Info("rate limited", "group", group, "client_ip", c.ClientIP())
+		seconds := int(math.Ceil(decision.RetryAfter.Seconds()))
+		c.Header("Retry-After", strconv.Itoa(seconds))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error":               "Too many requests",
+			"retry_after_seconds": seconds,
+		})
+	}
+}
+
+// AllowMessage reports whether a websocket client may send another message
+// of the given type. Types without a rule are not limited.
+func (l *Limiter) AllowMessage(ctx context.Context, userID uuid.UUID, ip, messageType string) bool {
+	rule, ok := l.cfg.WebSocket[messageType]
+	if !l.cfg.Enabled || !ok {
+		return true
+	}
+
+	decision := l.take(ctx, rule,
+		fmt.Sprintf("ws:%s:ip:%s", messageType, ip),
+		fmt.Sprintf("ws:%s:user:%s", messageType, userID),
+	)
+	if !decision.Allowed {
+		metrics.RateLimitRejections.WithLabelValues("websocket", messageType).Inc()
+	}
+	return decision.Allowed
+}
@@ -0,0 +1,195 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"storychain-backend/internal/config"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// twoPerSecond refills a token every half second and holds up to three.
+var twoPerSecond = config.RateRule{Requests: 2, Per: config.Duration{Duration: time.Second}, Burst: 3}
+
+// rewind makes a bucket look as if it was last touched d earlier.
+func rewind(s *MemoryStore, key string, d time.Duration) {
+	s.buckets[key].updated = s.buckets[key].updated.Add(-d)
+}
+
+// drain takes tokens until the bucket denies, and returns how many it gave.
+func drain(t *testing.T, s *MemoryStore, key string) (int, Decision) {
+	t.Helper()
+	for taken := 0; taken < 100; taken++ {
+		decision, err := s.Take(context.Background(), key, twoPerSecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !decision.Allowed {
+			return taken, decision
+		}
+	}
+	t.Fatal("bucket never ran out")
+	return 0, Decision{}
+}
+
+func TestMemoryStoreRefill(t *testing.T) {
+	tests := []struct {
+		name  string
+		idle  time.Duration
+		taken int
+	}{
+		{"no time passed", 0, 0},
+		{"less than a token", 400 * time.Millisecond, 0},
+		{"exactly one token", 500 * time.Millisecond, 1},
+		{"two tokens", 1100 * time.Millisecond, 2},
+		{"refill stops at the burst", time.Hour, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			if taken, _ := drain(t, s, "k"); taken != twoPerSecond.Burst {
+				t.Fatalf("new bucket gave %d tokens, want the burst of %d", taken, twoPerSecond.Burst)
+			}
+			rewind(s, "k", tt.idle)
+			taken, decision := drain(t, s, "k")
+			if taken != tt.taken {
+				t.Errorf("after %v idle took %d tokens, want %d", tt.idle, taken, tt.taken)
+			}
+			if decision.RetryAfter <= 0 || decision.RetryAfter > 500*time.Millisecond {
+				t.Errorf("RetryAfter = %v, want up to one token's refill time", decision.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestMemoryStoreRetryAfter(t *testing.T) {
+	s := NewMemoryStore()
+	drain(t, s, "k")
+	rewind(s, "k", 300*time.Millisecond)
+	decision, _ := s.Take(context.Background(), "k", twoPerSecond)
+	if decision.Allowed {
+		t.Fatal("took a token before it refilled")
+	}
+	// 0.6 of a token has refilled, leaving 0.4 at two tokens a second
+	if want := 200 * time.Millisecond; decision.RetryAfter > want || decision.RetryAfter < want-10*time.Millisecond {
+		t.Errorf("RetryAfter = %v, want about %v", decision.RetryAfter, want)
+	}
+}
+
+func TestMemoryStoreKeysAndSweep(t *testing.T) {
+	s := NewMemoryStore()
+	drain(t, s, "a")
+	if decision, _ := s.Take(context.Background(), "b", twoPerSecond); !decision.Allowed {
+		t.Fatal("an empty bucket limited another key")
+	}
+
+	rewind(s, "a", idleBucketTTL+time.Minute)
+	s.lastSweep = s.lastSweep.Add(-sweepInterval - time.Minute)
+	s.Take(context.Background(), "b", twoPerSecond)
+	if _, ok := s.buckets["a"]; ok {
+		t.Error("sweep kept an idle bucket")
+	}
+	if _, ok := s.buckets["b"]; !ok {
+		t.Error("sweep dropped a bucket in use")
+	}
+}
+
+type fakeStore map[string]Decision
+
+func (f fakeStore) Take(_ context.Context, key string, _ config.RateRule) (Decision, error) {
+	decision, ok := f[key]
+	if !ok {
+		return Decision{}, errors.New("store unavailable")
+	}
+	return decision, nil
+}
+
+func TestLimiterTake(t *testing.T) {
+	store := fakeStore{
+		"open":  {Allowed: true},
+		"short": {RetryAfter: time.Second},
+		"long":  {RetryAfter: 3 * time.Second},
+	}
+	l := NewLimiter(config.RateLimitConfig{}, store, slog.New(slog.DiscardHandler))
+	tests := []struct {
+		name string
+		keys []string
+		want Decision
+	}{
+		{"all allowed", []string{"open"}, Decision{Allowed: true}},
+		{"store error fails open", []string{"broken"}, Decision{Allowed: true}},
+		{"any key denies", []string{"open", "short"}, Decision{RetryAfter: time.Second}},
+		{"longest wait wins", []string{"long", "broken", "short"}, Decision{RetryAfter: 3 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := l.take(context.Background(), twoPerSecond, tt.keys...); got != tt.want {
+				t.Errorf("take(%v) = %+v, want %+v", tt.keys, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	once := config.RateRule{Requests: 1, Per: config.Duration{Duration: time.Minute}, Burst: 1}
+	cfg := config.RateLimitConfig{Enabled: true, Routes: map[string]config.RateRule{"write": once}}
+	l := NewLimiter(cfg, NewMemoryStore(), slog.New(slog.DiscardHandler))
+
+	router := gin.New()
+	router.GET("/write", l.Middleware("write"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/read", l.Middleware("read"), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/write", http.StatusNoContent},
+		{"/write", http.StatusTooManyRequests},
+		{"/read", http.StatusNoContent},
+		{"/read", http.StatusNoContent},
+	}
+	for i, tt := range tests {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if w.Code != tt.status {
+			t.Fatalf("request %d to %s = %d, want %d", i+1, tt.path, w.Code, tt.status)
+		}
+		if tt.status == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "60" {
+			t.Errorf("Retry-After = %q, want 60", w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestAllowMessage(t *testing.T) {
+	once := config.RateRule{Requests: 1, Per: config.Duration{Duration: time.Minute}, Burst: 1}
+	cfg := config.RateLimitConfig{Enabled: true, WebSocket: map[string]config.RateRule{"cursor": once}}
+	l := NewLimiter(cfg, NewMemoryStore(), slog.New(slog.DiscardHandler))
+	ctx := context.Background()
+	ann, bob := uuid.New(), uuid.New()
+
+	if !l.AllowMessage(ctx, ann, "10.0.0.1", "cursor") {
+		t.Fatal("first message was limited")
+	}
+	if l.AllowMessage(ctx, ann, "10.0.0.2", "cursor") {
+		t.Error("the same user was not limited from another address")
+	}
+	if l.AllowMessage(ctx, bob, "10.0.0.1", "cursor") {
+		t.Error("another user was not limited from the same address")
+	}
+	if !l.AllowMessage(ctx, ann, "10.0.0.1", "presence") {
+		t.Error("a message type without a rule was limited")
+	}
+
+	cfg.Enabled = false
+	if !NewLimiter(cfg, NewMemoryStore(), slog.New(slog.DiscardHandler)).AllowMessage(ctx, ann, "10.0.0.1", "cursor") {
+		t.Error("a disabled limiter limited a message")
+	}
+}
@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"storychain-backend/internal/config"
+)
+
+// idleBucketTTL is how long an untouched bucket is kept. Any bucket idle
+// this long has refilled completely, so dropping it changes nothing.
+const idleBucketTTL = time.Hour
+
+// sweepInterval is how often stale buckets are removed.
+const sweepInterval = 10 * time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryStore keeps buckets in process memory. Limits are per instance.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Take(_ context.Context, key string, rule config.RateRule) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sweepInterval {
+		for k, b := range s.buckets {
+			if now.Sub(b.updated) > idleBucketTTL {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	rate := refillRate(rule)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(rule.Burst), updated: now}
+		s.buckets[key] = b
+	}
+	b.tokens = min(float64(rule.Burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return Decision{Allowed: true}, nil
+	}
+	wait := time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	return Decision{Allowed: false, RetryAfter: wait}, nil
+}
+
+// refilledSQL is the bucket's token count after refilling. In ON CONFLICT
+// DO UPDATE, b refers to the locked existing row, so concurrent takes on the
+// same key are serialized.
+const refilledSQL = `LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM (now() - b.updated_at)) * $3::float8)`
+
+// PostgresStore keeps buckets in the rate_limit_buckets table so every
+// instance shares the same limits. Each Take is a single atomic upsert.
+type PostgresStore struct {
+	db        *sql.DB
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, lastSweep: time.Now()}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, rule config.RateRule) (Decision, error) {
+	s.sweep(ctx)
+
+	rate := refillRate(rule)
+	var tokens float64
+	var allowed bool
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		VALUES ($1, $2::float8 - 1, true, now())
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = `+refilledSQL+` - CASE WHEN `+refilledSQL+` >= 1 THEN 1 ELSE 0 END,
+			allowed = `+refilledSQL+` >= 1,
+			updated_at = now()
+		RETURNING b.tokens, b.allowed`,
+		key, rule.Burst, rate,
+	).Scan(&tokens, &allowed)
+	if err != nil {
+		return Decision{}, err
+	}
+
+	if allowed {
+		return Decision{Allowed: true}, nil
+	}
+	wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+	return Decision{Allowed: false, RetryAfter: wait}, nil
+}
+
+// sweep deletes idle buckets at most once per sweepInterval per instance.
+func (s *PostgresStore) sweep(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < sweepInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	s.db.ExecContext(ctx, "DELETE FROM rate_limit_buckets WHERE updated_at < now() - $1 * interval '1 second'",
+		idleBucketTTL.Seconds())
+}
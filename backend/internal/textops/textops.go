@@ -0,0 +1,162 @@
+// Package textops describes how committed edits move positions in a document.
+package textops
+
+import "storychain-backend/internal/models"
+
+// Normalize clamps a change to what updateDocument actually applies to content
+// of the given length: inserts past the end append, and deletes or replaces
+// that run past the end stop at it.
+func Normalize(change models.TextChange, contentLen int) models.TextChange {
+	if change.Position < 0 {
+		change.Position = 0
+	}
+	if change.Length < 0 {
+		change.Length = 0
+	}
+
+	switch change.ChangeType {
+	case "insert":
+		if change.Position > contentLen {
+			change.Position = contentLen
+		}
+		change.Length = 0
+	case "delete", "replace":
+		if change.Position >= contentLen {
+			change.Position = contentLen
+			change.Length = 0
+		} else if change.Position+change.Length > contentLen {
+			change.Length = contentLen - change.Position
+		}
+		if change.ChangeType == "delete" {
+			change.Content = ""
+		}
+	}
+	return change
+}
+
+// TransformPosition moves pos across a normalized change. When text is
+// inserted exactly at pos, stickRight decides whether pos ends up after it.
+func TransformPosition(pos int, change models.TextChange, stickRight bool) int {
+	inserted := 0
+	if change.ChangeType == "insert" || change.ChangeType == "replace" {
+		inserted = len(change.Content)
+	}
+	removed := 0
+	if change.ChangeType == "delete" || change.ChangeType == "replace" {
+		removed = change.Length
+	}
+
+	start := change.Position
+	end := start + removed
+	switch {
+	case pos < start:
+		return pos
+	case pos == start && removed == 0:
+		if stickRight {
+			return pos + inserted
+		}
+		return pos
+	case pos == start:
+		return pos
+	case pos >= end:
+		return pos - removed + inserted
+	default:
+		// pos was inside removed text; collapse it onto the edit
+		if stickRight {
+			return start + inserted
+		}
+		return start
+	}
+}
+
+// TransformRange moves the half-open range [start, end) across a normalized
+// change. Text inserted at either boundary stays outside the range.
+func TransformRange(start, end int, change models.TextChange) (int, int) {
+	newStart := TransformPosition(start, change, true)
+	newEnd := TransformPosition(end, change, false)
+	if newEnd < newStart {
+		newEnd = newStart
+	}
+	return newStart, newEnd
+}
+
+// Touches reports whether a normalized change edits text inside the range
+// [start, end). Inserting exactly at either boundary does not touch it.
+func Touches(change models.TextChange, start, end int) bool {
+	removed := 0
+	if change.ChangeType == "delete" || change.ChangeType == "replace" {
+		removed = change.Length
+	}
+	if removed == 0 {
+		return change.Position > start && change.Position < end
+	}
+	return change.Position < end && change.Position+removed > start
+}
+
+// Rebase moves a pending, normalized change so it applies after another
+// normalized change that was committed first. It reports false when the
+// committed change edited the text the pending one deletes or replaces.
+func Rebase(pending, committed models.TextChange) (models.TextChange, bool) {
+	if pending.ChangeType == "insert" || pending.Length == 0 {
+		// The committed text goes first when both insert at one spot
+		pending.Position = TransformPosition(pending.Position, committed, true)
+		return pending, true
+	}
+	if Touches(committed, pending.Position, pending.Position+pending.Length) {
+		return pending, false
+	}
+	start, end := TransformRange(pending.Position, pending.Position+pending.Length, committed)
+	pending.Position, pending.Length = start, end-start
+	return pending, true
+}
+
+// Apply returns content with a normalized change applied.
+func Apply(content string, change models.TextChange) string {
+	end := change.Position
+	inserted := change.Content
+	switch change.ChangeType {
+	case "delete":
+		end += change.Length
+		inserted = ""
+	case "replace":
+		end += change.Length
+	}
+	return content[:change.Position] + inserted + content[end:]
+}
+
+// Removed returns the text a normalized change deletes or replaces in content.
+func Removed(content string, change models.TextChange) string {
+	if change.ChangeType == "insert" {
+		return ""
+	}
+	return content[change.Position : change.Position+change.Length]
+}
+
+// Invert returns the change that undoes a normalized change, given the text
+// it removed.
+func Invert(change models.TextChange, removed string) models.TextChange {
+	inverse := change
+	switch change.ChangeType {
+	case "insert":
+		inverse.ChangeType, inverse.Content, inverse.Length = "delete", "", len(change.Content)
+	case "delete":
+		inverse.ChangeType, inverse.Content, inverse.Length = "insert", removed, 0
+	case "replace":
+		inverse.Content, inverse.Length = removed, len(change.Content)
+	}
+	return inverse
+}
+
+// Delta returns how many bytes a normalized change adds to a document;
+// it is negative when the change removes more than it inserts.
+func Delta(change models.TextChange) int {
+	switch change.ChangeType {
+	case "insert":
+		return len(change.Content)
+	case "delete":
+		return -change.Length
+	case "replace":
+		return len(change.Content) - change.Length
+	}
+	return 0
+}
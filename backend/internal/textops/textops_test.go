@@ -0,0 +1,231 @@
+package textops
+
+import (
+	"testing"
+
+	"storychain-backend/internal/models"
+)
+
+func ins(pos int, text string) models.TextChange {
+	return models.TextChange{ChangeType: "insert", Position: pos, Content: text}
+}
+
+func del(pos, length int) models.TextChange {
+	return models.TextChange{ChangeType: "delete", Position: pos, Length: length}
+}
+
+func rep(pos, length int, text string) models.TextChange {
+	return models.TextChange{ChangeType: "replace", Position: pos, Length: length, Content: text}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		change models.TextChange
+		length int
+		want   models.TextChange
+	}{
+		{"insert inside", ins(3, "x"), 10, ins(3, "x")},
+		{"insert past end appends", ins(20, "x"), 10, ins(10, "x")},
+		{"insert before start", ins(-4, "x"), 10, ins(0, "x")},
+		{"insert drops length", models.TextChange{ChangeType: "insert", Position: 2, Length: 5, Content: "x"}, 10, ins(2, "x")},
+		{"delete inside", del(2, 3), 10, del(2, 3)},
+		{"delete past end stops at it", del(8, 5), 10, del(8, 2)},
+		{"delete from end removes nothing", del(12, 3), 10, del(10, 0)},
+		{"delete negative length", del(2, -3), 10, del(2, 0)},
+		{"delete drops content", models.TextChange{ChangeType: "delete", Position: 1, Length: 2, Content: "x"}, 10, del(1, 2)},
+		{"replace past end stops at it", rep(7, 9, "ab"), 10, rep(7, 3, "ab")},
+		{"replace in empty document", rep(0, 4, "ab"), 0, rep(0, 0, "ab")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Normalize(tt.change, tt.length); got != tt.want {
+				t.Errorf("Normalize(%+v, %d) = %+v, want %+v", tt.change, tt.length, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformPosition(t *testing.T) {
+	tests := []struct {
+		name       string
+		pos        int
+		change     models.TextChange
+		stickRight bool
+		want       int
+	}{
+		{"insert after pos", 2, ins(5, "abc"), false, 2},
+		{"insert before pos", 7, ins(5, "abc"), false, 10},
+		{"insert at pos sticking left", 5, ins(5, "abc"), false, 5},
+		{"insert at pos sticking right", 5, ins(5, "abc"), true, 8},
+		{"delete before pos", 9, del(2, 3), false, 6},
+		{"delete after pos", 1, del(2, 3), false, 1},
+		{"delete at pos", 2, del(2, 3), true, 2},
+		{"pos inside delete sticking left", 3, del(2, 3), false, 2},
+		{"pos inside delete sticking right", 3, del(2, 3), true, 2},
+		{"pos at end of delete", 5, del(2, 3), false, 2},
+		{"pos inside replace sticking right", 3, rep(2, 3, "xy"), true, 4},
+		{"pos inside replace sticking left", 3, rep(2, 3, "xy"), false, 2},
+		{"replace before pos", 8, rep(2, 3, "wxyz"), false, 9},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TransformPosition(tt.pos, tt.change, tt.stickRight); got != tt.want {
+				t.Errorf("TransformPosition(%d, %+v, %v) = %d, want %d", tt.pos, tt.change, tt.stickRight, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransformRange(t *testing.T) {
+	tests := []struct {
+		name               string
+		start, end         int
+		change             models.TextChange
+		wantStart, wantEnd int
+	}{
+		{"edit before range shifts it", 5, 10, ins(0, "ab"), 7, 12},
+		{"edit after range leaves it", 5, 10, ins(12, "ab"), 5, 10},
+		{"insert at start stays outside", 5, 10, ins(5, "ab"), 7, 12},
+		{"insert at end stays outside", 5, 10, ins(10, "ab"), 5, 10},
+		{"insert inside grows it", 5, 10, ins(7, "ab"), 5, 12},
+		{"delete inside shrinks it", 5, 10, del(6, 2), 5, 8},
+		{"delete over start cuts it", 5, 10, del(3, 4), 3, 6},
+		{"delete covering it collapses it", 5, 10, del(2, 10), 2, 2},
+		{"replace covering it collapses after the new text", 5, 10, rep(4, 8, "xyz"), 7, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := TransformRange(tt.start, tt.end, tt.change)
+			if start != tt.wantStart || end != tt.wantEnd {
+				t.Errorf("TransformRange(%d, %d, %+v) = (%d, %d), want (%d, %d)",
+					tt.start, tt.end, tt.change, start, end, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestTouches(t *testing.T) {
+	tests := []struct {
+		name   string
+		change models.TextChange
+		want   bool
+	}{
+		{"insert at start", ins(5, "x"), false},
+		{"insert at end", ins(10, "x"), false},
+		{"insert inside", ins(6, "x"), true},
+		{"insert outside", ins(2, "x"), false},
+		{"delete ending at start", del(3, 2), false},
+		{"delete starting at end", del(10, 2), false},
+		{"delete overlapping start", del(4, 2), true},
+		{"delete overlapping end", del(9, 3), true},
+		{"delete covering range", del(0, 20), true},
+		{"empty replace inside", rep(7, 0, "x"), true},
+		{"replace inside", rep(6, 2, "x"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Touches(tt.change, 5, 10); got != tt.want {
+				t.Errorf("Touches(%+v, 5, 10) = %v, want %v", tt.change, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyAndInvert(t *testing.T) {
+	const content = "the quick brown fox"
+	tests := []struct {
+		name    string
+		change  models.TextChange
+		want    string
+		removed string
+		delta   int
+	}{
+		{"insert", ins(4, "very "), "the very quick brown fox", "", 5},
+		{"insert at end", ins(19, "!"), "the quick brown fox!", "", 1},
+		{"delete", del(4, 6), "the brown fox", "quick ", -6},
+		{"replace", rep(10, 5, "red"), "the quick red fox", "brown", -2},
+		{"replace multibyte", rep(16, 3, "café"), "the quick brown café", "fox", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Apply(content, tt.change)
+			if got != tt.want {
+				t.Fatalf("Apply = %q, want %q", got, tt.want)
+			}
+			removed := Removed(content, tt.change)
+			if removed != tt.removed {
+				t.Errorf("Removed = %q, want %q", removed, tt.removed)
+			}
+			if delta := Delta(tt.change); delta != tt.delta || len(got)-len(content) != delta {
+				t.Errorf("Delta = %d, want %d", delta, tt.delta)
+			}
+			if undone := Apply(got, Invert(tt.change, removed)); undone != content {
+				t.Errorf("applying the inverse gave %q, want %q", undone, content)
+			}
+		})
+	}
+}
+
+func TestRebase(t *testing.T) {
+	tests := []struct {
+		name      string
+		pending   models.TextChange
+		committed models.TextChange
+		want      models.TextChange
+		ok        bool
+	}{
+		{"insert after committed insert", ins(10, "x"), ins(2, "ab"), ins(12, "x"), true},
+		{"insert before committed insert", ins(1, "x"), ins(2, "ab"), ins(1, "x"), true},
+		{"inserts at one spot put committed first", ins(2, "x"), ins(2, "ab"), ins(4, "x"), true},
+		{"insert inside committed delete", ins(4, "x"), del(2, 5), ins(2, "x"), true},
+		{"delete after committed insert", del(10, 3), ins(2, "ab"), del(12, 3), true},
+		{"delete after committed delete", del(10, 3), del(2, 4), del(6, 3), true},
+		{"delete before committed replace", del(0, 2), rep(5, 3, "xyz"), del(0, 2), true},
+		{"insert at start of pending delete", del(5, 3), ins(5, "ab"), del(7, 3), true},
+		{"insert at end of pending delete", del(5, 3), ins(8, "ab"), del(5, 3), true},
+		{"insert inside pending delete conflicts", del(5, 3), ins(6, "ab"), del(5, 3), false},
+		{"overlapping deletes conflict", del(5, 3), del(6, 4), del(5, 3), false},
+		{"replace over committed replace conflicts", rep(5, 3, "x"), rep(4, 2, "yy"), rep(5, 3, "x"), false},
+		{"replace after committed replace", rep(10, 2, "x"), rep(4, 2, "yyyy"), rep(12, 2, "x"), true},
+		{"empty replace is rebased like an insert", rep(6, 0, "x"), del(2, 2), rep(4, 0, "x"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Rebase(tt.pending, tt.committed)
+			if ok != tt.ok {
+				t.Fatalf("Rebase(%+v, %+v) ok = %v, want %v", tt.pending, tt.committed, ok, tt.ok)
+			}
+			if ok && got != tt.want {
+				t.Errorf("Rebase(%+v, %+v) = %+v, want %+v", tt.pending, tt.committed, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRebaseConverges checks that a rebased change does to the committed
+// content what the original would have done to the same text.
+func TestRebaseConverges(t *testing.T) {
+	const content = "one two three four"
+	tests := []struct {
+		name      string
+		pending   models.TextChange
+		committed models.TextChange
+		want      string
+	}{
+		{"replace after an insert", rep(8, 5, "3"), ins(0, "zero "), "zero one two 3 four"},
+		{"delete after a delete", del(13, 5), del(0, 4), "two three"},
+		{"insert before a replace", ins(4, "and "), rep(8, 5, "THREE"), "one and two THREE four"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rebased, ok := Rebase(tt.pending, tt.committed)
+			if !ok {
+				t.Fatalf("Rebase(%+v, %+v) conflicted", tt.pending, tt.committed)
+			}
+			if got := Apply(Apply(content, tt.committed), rebased); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
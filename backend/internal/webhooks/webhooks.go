@@ -0,0 +1,301 @@
+// Package webhooks delivers document events to registered endpoints. Events
+// are written to an outbox table and sent from there in the background, so
+// a slow or failing receiver never holds up an edit.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"storychain-backend/internal/config"
+
+	"github.com/google/uuid"
+)
+
+// Events that webhooks can subscribe to.
+const (
+	ChangeCommitted   = "change.committed"
+	ChangeReverted    = "change.reverted"
+	ModerationFlagged = "moderation.flagged"
+	UserJoined        = "user.joined"
+)
+
+// Events lists every event, in the order they are documented.
+var Events = []string{ChangeCommitted, ChangeReverted, ModerationFlagged, UserJoined}
+
+// Headers sent with every delivery.
+const (
+	EventHeader     = "X-StoryChain-Event"
+	DeliveryHeader  = "X-StoryChain-Delivery"
+	SignatureHeader = "X-StoryChain-Signature"
+)
+
+// batchSize is how many due deliveries one poll claims and sends at once.
+const batchSize = 20
+
+// pruneInterval is how often finished deliveries past retention are deleted.
+const pruneInterval = time.Hour
+
+// maxErrorLength caps the error text kept in the delivery log.
+const maxErrorLength = 500
+
+// Payload is the JSON body of a delivery.
+type Payload struct {
+	Event      string    `json:"event"`
+	DocumentID uuid.UUID `json:"document_id"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Data       any       `json:"data"`
+}
+
+// Dispatcher queues events in the outbox and delivers them.
+type Dispatcher struct {
+	db   *sql.DB
+	cfg  config.WebhooksConfig
+	http *http.Client
+	log  *slog.Logger
+	wake chan struct{}
+}
+
+func NewDispatcher(db *sql.DB, cfg config.WebhooksConfig, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		db:  db,
+		cfg: cfg,
+		http: &http.Client{
+			Timeout: cfg.Timeout.Duration,
+			// A redirect is answered as a failure rather than followed
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		log:  logger,
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// ValidEvent reports whether event is one webhooks can subscribe to.
+func ValidEvent(event string) bool {
+	for _, e := range Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Sign returns the signature header value for a body sent at t:
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<unix seconds>.<body>">".
+func Sign(secret string, t time.Time, body []byte) string {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "t=" + timestamp + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Enqueue adds a delivery of event to every webhook of the document that
+// subscribes to it. It only writes to the outbox.
+func (d *Dispatcher) Enqueue(documentID uuid.UUID, event string, data any) error {
+	body, err := json.Marshal(Payload{Event: event, DocumentID: documentID, OccurredAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	result, err := d.db.Exec(
+		`INSERT INTO webhook_deliveries (webhook_id, event, payload)
+		SELECT id, $2, $3 FROM webhooks WHERE document_id = $1 AND $2 = ANY(events)`,
+		documentID.String(), event, string(body),
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Run sends due deliveries until ctx is cancelled. Deliveries cut short by
+// cancellation are retried once their claim expires, here or on another
+// instance.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.cfg.PollInterval.Duration)
+	defer ticker.Stop()
+	var lastPrune time.Time
+	for {
+		// Keep going while full batches suggest more is due
+		for d.deliverDue(ctx) == batchSize {
+		}
+		if time.Since(lastPrune) >= pruneInterval {
+			lastPrune = time.Now()
+			d.prune(ctx)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// delivery is a claimed outbox row with what is needed to send it.
+type delivery struct {
+	id       uuid.UUID
+	event    string
+	payload  []byte
+	attempts int
+	url      string
+	secret   string
+}
+
+// deliverDue claims a batch of due deliveries, sends them concurrently and
+// returns how many it claimed.
+func (d *Dispatcher) deliverDue(ctx context.Context) int {
+	// The claim pushes next_attempt_at past the attempt so other instances
+	// skip these rows, and a crash mid-attempt still leads to a retry
+	claim := d.cfg.Timeout.Duration + time.Minute
+	rows, err := d.db.QueryContext(ctx,
+		`UPDATE webhook_deliveries d SET attempts = d.attempts + 1, next_attempt_at = $2
+		FROM webhooks w
+		WHERE w.id = d.webhook_id AND d.id IN (
+			SELECT id FROM webhook_deliveries WHERE status = 'pending' AND next_attempt_at <= CURRENT_TIMESTAMP
+			ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED)
+		RETURNING d.id, d.event, d.payload, d.attempts, w.url, w.secret`,
+		batchSize, time.Now().Add(claim),
+	)
+	if err != nil {
+		if ctx.Err() == nil {
+			d.log.Error("failed to claim webhook deliveries", "error", err)
+		}
+		return 0
+	}
+	var batch []delivery
+	for rows.Next() {
+		var item delivery
+		if err := rows.Scan(&item.id, &item.event, &item.payload, &item.attempts, &item.url, &item.secret); err != nil {
+			d.log.Error("failed to scan webhook delivery", "error", err)
+			continue
+		}
+		batch = append(batch, item)
+	}
+	rows.Close()
+
+	var wg sync.WaitGroup
+	for _, item := range batch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.attempt(ctx, item)
+		}()
+	}
+	wg.Wait()
+	return len(batch)
+}
+
+// attempt sends one delivery and records the outcome. Any 2xx response
+// counts as delivered.
+func (d *Dispatcher) attempt(ctx context.Context, item delivery) {
+	logger := d.log.With("delivery_id", item.id, "event", item.event, "attempt", item.attempts)
+	status, err := d.send(ctx, item)
+	if ctx.Err() != nil {
+		// Shutting down; the claim expires and the delivery is retried
+		return
+	}
+	var responseStatus sql.NullInt64
+	if status != 0 {
+		responseStatus = sql.NullInt64{Int64: int64(status), Valid: true}
+	}
+
+	if err == nil {
+		if _, err := d.db.Exec(
+			`UPDATE webhook_deliveries SET status = 'delivered', response_status = $2, last_error = '', finished_at = CURRENT_TIMESTAMP
+			WHERE id = $1`,
+			item.id.String(), responseStatus,
+		); err != nil {
+			logger.Error("failed to record webhook delivery", "error", err)
+		}
+		logger.Debug("webhook delivered", "status", status)
+		return
+	}
+
+	message := err.Error()
+	if len(message) > maxErrorLength {
+		message = message[:maxErrorLength]
+	}
+	if item.attempts >= d.cfg.MaxAttempts {
+		_, err = d.db.Exec(
+			`UPDATE webhook_deliveries SET status = 'failed', response_status = $2, last_error = $3, finished_at = CURRENT_TIMESTAMP
+			WHERE id = $1`,
+			item.id.String(), responseStatus, message,
+		)
+		logger.Warn("webhook delivery failed for good", "error", message)
+	} else {
+		retryAt := time.Now().Add(d.backoff(item.attempts))
+		_, err = d.db.Exec(
+			"UPDATE webhook_deliveries SET response_status = $2, last_error = $3, next_attempt_at = $4 WHERE id = $1",
+			item.id.String(), responseStatus, message, retryAt,
+		)
+		logger.Info("webhook delivery will be retried", "error", message, "retry_at", retryAt)
+	}
+	if err != nil {
+		logger.Error("failed to record webhook delivery", "error", err)
+	}
+}
+
+// send posts the payload and returns the response status, if any.
+func (d *Dispatcher) send(ctx context.Context, item delivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, item.url, bytes.NewReader(item.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "StoryChain-Webhooks/1")
+	req.Header.Set(EventHeader, item.event)
+	req.Header.Set(DeliveryHeader, item.id.String())
+	req.Header.Set(SignatureHeader, Sign(item.secret, time.Now(), item.payload))
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Read a little of the body so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("receiver answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff is the delay before the attempt after attempts failed ones:
+// RetryBase doubled for each earlier failure, at most RetryMax.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.cfg.RetryBase.Duration
+	for i := 1; i < attempts && delay < d.cfg.RetryMax.Duration; i++ {
+		delay *= 2
+	}
+	return min(delay, d.cfg.RetryMax.Duration)
+}
+
+// prune deletes finished deliveries older than the log retention.
+func (d *Dispatcher) prune(ctx context.Context) {
+	if _, err := d.db.ExecContext(ctx,
+		"DELETE FROM webhook_deliveries WHERE status <> 'pending' AND finished_at < $1",
+		time.Now().Add(-d.cfg.LogRetention.Duration),
+	); err != nil && ctx.Err() == nil {
+		d.log.Warn("failed to prune webhook deliveries", "error", err)
+	}
+}
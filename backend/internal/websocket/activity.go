@@ -0,0 +1,61 @@
+package websocket
+
+import "github.com/google/uuid"
+
+// activity tracks the most users connected at once, overall and per
+// document, since the hub was last sampled. It is guarded by the hub lock.
+type activity struct {
+	total      int
+	byDocument map[uuid.UUID]int
+}
+
+// countUsersLocked returns how many distinct users are connected, overall and
+// per document. It must be called with the hub lock held.
+func (h *Hub) countUsersLocked() (int, map[uuid.UUID]int) {
+	all := make(map[uuid.UUID]bool)
+	seen := make(map[uuid.UUID]map[uuid.UUID]bool)
+	for client := range h.Clients {
+		if client.Watcher {
+			continue
+		}
+		all[client.ID] = true
+		if seen[client.DocumentID] == nil {
+			seen[client.DocumentID] = make(map[uuid.UUID]bool)
+		}
+		seen[client.DocumentID][client.ID] = true
+	}
+	byDocument := make(map[uuid.UUID]int, len(seen))
+	for documentID, users := range seen {
+		byDocument[documentID] = len(users)
+	}
+	return len(all), byDocument
+}
+
+// notePeakLocked raises the peaks after a client joined its document. It
+// must be called with the hub lock held.
+func (h *Hub) notePeakLocked(client *Client) {
+	all := make(map[uuid.UUID]bool)
+	onDocument := make(map[uuid.UUID]bool)
+	for c := range h.Clients {
+		if c.Watcher {
+			continue
+		}
+		all[c.ID] = true
+		if c.DocumentID == client.DocumentID {
+			onDocument[c.ID] = true
+		}
+	}
+	h.peaks.total = max(h.peaks.total, len(all))
+	h.peaks.byDocument[client.DocumentID] = max(h.peaks.byDocument[client.DocumentID], len(onDocument))
+}
+
+// TakePeaks returns the most users connected at once since the previous
+// call, overall and per document, and starts the next period from the
+// current counts.
+func (h *Hub) TakePeaks() (int, map[uuid.UUID]int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	total, byDocument := h.peaks.total, h.peaks.byDocument
+	h.peaks.total, h.peaks.byDocument = h.countUsersLocked()
+	return total, byDocument
+}
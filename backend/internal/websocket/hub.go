@@ -1,102 +1,374 @@
 package websocket
 
 import (
-    "encoding/json"
-    "log"
-    "net/http"
-    "sync"
-    "time"
+	"context"
+	"encoding/json"
+	"hash/fnv"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 
+	"storychain-backend/internal/config"
+	"storychain-backend/internal/logging"
+	"storychain-backend/internal/metrics"
 	"storychain-backend/internal/models"
+	"storychain-backend/internal/origin"
+	"storychain-backend/internal/textops"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// idleAfter is how long a client may stay silent before presence reports it as idle.
+const idleAfter = 2 * time.Minute
+
+// heartbeatInterval is how often Run records that its loop is still turning.
+const heartbeatInterval = 5 * time.Second
+
+// Clients told to go away reconnect after a random delay in this range so
+// they do not all hit the next instance at once.
+const (
+	reconnectMinDelay = 1 * time.Second
+	reconnectMaxDelay = 5 * time.Second
+)
+
+// ReconnectDelay picks how long a client told to go away should wait
+// before reconnecting.
+func ReconnectDelay() time.Duration {
+	return reconnectMinDelay + rand.N(reconnectMaxDelay-reconnectMinDelay)
+}
+
+// maxNameLength caps display names, in characters.
+const maxNameLength = 50
+
+// presenceColors is the palette user colors are picked from.
+var presenceColors = []string{
+	"#e6194b", "#3cb44b", "#4363d8", "#f58231", "#911eb4",
+	"#42d4f4", "#f032e6", "#469990", "#9a6324", "#800000",
 }
 
 type Client struct {
-	ID       uuid.UUID
-	Name     string
-	Conn     *websocket.Conn
-	Send     chan []byte
-	Hub      *Hub
-	Cooldown time.Time
+	ID         uuid.UUID
+	Name       string
+	Color      string
+	DocumentID uuid.UUID
+	Conn       *websocket.Conn
+	Send       chan []byte
+	Hub        *Hub
+	Cursor     *models.CursorState
+	LastActive time.Time
+	JoinedAt   time.Time
+	RequestID  string
+	IP         string
+	// ReadOnly clients may watch but not take chain turns
+	ReadOnly bool
+	// Anonymous clients have no session, so they cannot make the REST edits
+	// that use chain turns
+	Anonymous bool
+	// Watcher clients, such as event streams, have no connection and are
+	// left out of presence, activity peaks and turn order
+	Watcher bool
+	log     *slog.Logger
+}
+
+// inboundMessage is a client message whose payload is decoded per type.
+type inboundMessage struct {
+	Type string          `json:"type"`
+	Data json.RawMessage `json:"data"`
+}
+
+// MessageLimiter decides whether a client may send another message of a type.
+type MessageLimiter interface {
+	AllowMessage(ctx context.Context, userID uuid.UUID, ip, messageType string) bool
+}
+
+// documentMessage is a broadcast limited to the clients of one document.
+type documentMessage struct {
+	documentID uuid.UUID
+	data       []byte
 }
 
 type Hub struct {
-    Clients    map[*Client]bool
-    Broadcast  chan []byte
-    Register   chan *Client
-    Unregister chan *Client
-    mu         sync.RWMutex
-}
-
-func NewHub() *Hub {
-    return &Hub{
-        Clients:    make(map[*Client]bool),
-        // Buffer broadcasts to avoid dropping messages and to decouple producers
-        Broadcast:  make(chan []byte, 256),
-        Register:   make(chan *Client),
-        Unregister: make(chan *Client),
-    }
-}
-
-func (h *Hub) Run() {
-    for {
-        select {
-        case client := <-h.Register:
-            h.mu.Lock()
-            h.Clients[client] = true
-            h.mu.Unlock()
-            
-            h.broadcastUserPresence(client.ID, client.Name, "joined")
-            log.Printf("Client (%s) connected", client.ID)
+	Clients           map[*Client]bool
+	Broadcast         chan []byte
+	Register          chan *Client
+	Unregister        chan *Client
+	documentBroadcast chan documentMessage
+	mu                sync.RWMutex
+	cfg               *config.Config
+	upgrader          websocket.Upgrader
+	limiter           MessageLimiter
+	log               *slog.Logger
+	heartbeat         atomic.Int64
+	stopping          atomic.Bool
+	done              chan struct{}
+	// chains holds turn order for documents in story chain mode, under mu
+	chains map[uuid.UUID]*chain
+	// peaks holds the most users connected at once since the last sample, under mu
+	peaks activity
+	// onJoin is told about every client that joins a document, under mu
+	onJoin func(documentID, userID uuid.UUID, userName string)
+}
+
+// NewHub creates a hub whose websocket upgrades only accept browser origins
+// allowed by origins and whose incoming messages are limited by limiter.
+func NewHub(cfg *config.Config, origins *origin.Policy, limiter MessageLimiter, logger *slog.Logger) *Hub {
+	return &Hub{
+		cfg:      cfg,
+		upgrader: websocket.Upgrader{CheckOrigin: origins.CheckOrigin},
+		limiter:  limiter,
+		log:      logger,
+		Clients:  make(map[*Client]bool),
+		// Buffer broadcasts to avoid dropping messages and to decouple producers
+		Broadcast:         make(chan []byte, cfg.WebSocket.BroadcastBuffer),
+		Register:          make(chan *Client),
+		Unregister:        make(chan *Client),
+		documentBroadcast: make(chan documentMessage, cfg.WebSocket.BroadcastBuffer),
+		done:              make(chan struct{}),
+		chains:            make(map[uuid.UUID]*chain),
+		peaks:             activity{byDocument: make(map[uuid.UUID]int)},
+	}
+}
+
+// Run serves the hub until ctx is cancelled, then tells every client to go
+// away and reconnect elsewhere before closing their connections.
+func (h *Hub) Run(ctx context.Context) {
+	ticker := time.NewTicker(heartbeatInterval)
+	defer ticker.Stop()
+	defer close(h.done)
+	h.heartbeat.Store(time.Now().UnixNano())
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.goAway()
+			return
+
+		case now := <-ticker.C:
+			h.heartbeat.Store(now.UnixNano())
+
+		case client := <-h.Register:
+			h.mu.Lock()
+			h.Clients[client] = true
+			onJoin := h.onJoin
+			if !client.Watcher {
+				h.notePeakLocked(client)
+			}
+			h.mu.Unlock()
+			metrics.WebSocketConnections.WithLabelValues(client.DocumentID.String()).Inc()
+
+			h.sendPresenceSnapshot(client)
+			if client.Watcher {
+				client.log.Info("event stream subscribed")
+				continue
+			}
+			if onJoin != nil {
+				go onJoin(client.DocumentID, client.ID, client.Name)
+			}
+			h.broadcastUserPresence(client, "joined")
+			h.joinChain(client)
+			client.log.Info("websocket client connected")
 
 		case client := <-h.Unregister:
 			h.mu.Lock()
 			if _, ok := h.Clients[client]; ok {
 				delete(h.Clients, client)
 				close(client.Send)
+				metrics.WebSocketConnections.WithLabelValues(client.DocumentID.String()).Dec()
 			}
 			h.mu.Unlock()
-			
-            h.broadcastUserPresence(client.ID, client.Name, "left")
-            log.Printf("Client (%s) disconnected", client.ID)
-
-        case message := <-h.Broadcast:
-            log.Printf("Hub broadcasting message to %d clients", len(h.Clients))
-            // Send to all clients; collect any that need removal, then remove under write lock
-            var toRemove []*Client
-            h.mu.RLock()
-            for client := range h.Clients {
-                select {
-                case client.Send <- message:
-                    // ok
-                default:
-                    // Client's send buffer is full; mark for removal
-                    toRemove = append(toRemove, client)
-                }
-            }
-            h.mu.RUnlock()
-            if len(toRemove) > 0 {
-                h.mu.Lock()
-                for _, client := range toRemove {
-                    if h.Clients[client] {
-                        log.Printf("Removing slow client (%s)", client.ID)
-                        close(client.Send)
-                        delete(h.Clients, client)
-                    }
-                }
-                h.mu.Unlock()
-            }
-        }
-    }
+			if client.Watcher {
+				client.log.Info("event stream unsubscribed")
+				continue
+			}
+
+			h.broadcastUserPresence(client, "left")
+			h.leaveChain(client)
+			client.log.Info("websocket client disconnected")
+
+		case message := <-h.Broadcast:
+			h.log.Debug("hub broadcasting message", "clients", h.GetOnlineCount())
+			h.deliver(message, func(*Client) bool { return true })
+
+		case message := <-h.documentBroadcast:
+			h.deliver(message.data, func(client *Client) bool {
+				return client.DocumentID == message.documentID
+			})
+		}
+	}
+}
+
+// deliver sends a message to every client matching the filter, dropping
+// clients whose send buffer is full.
+func (h *Hub) deliver(message []byte, include func(*Client) bool) {
+	// Send to matching clients; collect any that need removal, then remove under write lock
+	var toRemove []*Client
+	h.mu.RLock()
+	for client := range h.Clients {
+		if !include(client) {
+			continue
+		}
+		select {
+		case client.Send <- message:
+			// ok
+		default:
+			// Client's send buffer is full; mark for removal
+			toRemove = append(toRemove, client)
+		}
+	}
+	h.mu.RUnlock()
+	if len(toRemove) > 0 {
+		h.mu.Lock()
+		for _, client := range toRemove {
+			if h.Clients[client] {
+				client.log.Warn("removing slow websocket client")
+				close(client.Send)
+				delete(h.Clients, client)
+				metrics.WebSocketConnections.WithLabelValues(client.DocumentID.String()).Dec()
+				metrics.SlowClientEvictions.Inc()
+			}
+		}
+		h.mu.Unlock()
+	}
+}
+
+// BroadcastToDocument queues a message for the clients of one document only.
+func (h *Hub) BroadcastToDocument(documentID uuid.UUID, data []byte) {
+	h.documentBroadcast <- documentMessage{documentID: documentID, data: data}
+}
+
+// OnJoin sets a function called, on its own goroutine, whenever a client
+// joins a document.
+func (h *Hub) OnJoin(fn func(documentID, userID uuid.UUID, userName string)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onJoin = fn
+}
+
+// Watch subscribes to a document's broadcasts without a websocket, for
+// read-only consumers such as event streams. Messages arrive on the
+// returned client's Send channel, starting with a presence snapshot, until
+// Leave or the hub closes it. It fails once the hub has stopped.
+func (h *Hub) Watch(c *gin.Context, documentID, userID uuid.UUID) (*Client, bool) {
+	requestID := logging.GetRequestID(c)
+	client := &Client{
+		ID:         userID,
+		Name:       "Watcher",
+		Color:      colorFor(userID),
+		DocumentID: documentID,
+		Send:       make(chan []byte, h.cfg.WebSocket.SendBuffer),
+		Hub:        h,
+		LastActive: time.Now(),
+		RequestID:  requestID,
+		IP:         c.ClientIP(),
+		ReadOnly:   true,
+		Watcher:    true,
+		log: h.log.With(
+			"request_id", requestID,
+			"user_id", userID,
+			"document_id", documentID,
+		),
+	}
+	select {
+	case h.Register <- client:
+		return client, true
+	case <-h.done:
+		return nil, false
+	}
+}
+
+// Leave unsubscribes a client added with Watch.
+func (h *Hub) Leave(client *Client) {
+	select {
+	case h.Unregister <- client:
+	case <-h.done:
+	}
+}
+
+// Done is closed once Run has returned.
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// goAway sends every client a going_away message with a reconnect hint and
+// closes its send channel, which makes its writePump close the connection.
+func (h *Hub) goAway() {
+	h.stopping.Store(true)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Flush anything still queued so clients see the final changes first
+	for {
+		select {
+		case message := <-h.Broadcast:
+			h.deliverLocked(message, func(*Client) bool { return true })
+			continue
+		case message := <-h.documentBroadcast:
+			h.deliverLocked(message.data, func(client *Client) bool {
+				return client.DocumentID == message.documentID
+			})
+			continue
+		default:
+		}
+		break
+	}
+
+	for client := range h.Clients {
+		delay := ReconnectDelay()
+		message := models.WebSocketMessage{
+			Type: "going_away",
+			Data: models.GoingAway{
+				Reason:           "server_shutdown",
+				ReconnectAfterMS: delay.Milliseconds(),
+			},
+		}
+		if data, err := json.Marshal(message); err == nil {
+			select {
+			case client.Send <- data:
+			default:
+			}
+		}
+		close(client.Send)
+		delete(h.Clients, client)
+		metrics.WebSocketConnections.WithLabelValues(client.DocumentID.String()).Dec()
+	}
+	h.log.Info("websocket hub stopped")
+}
+
+// deliverLocked is deliver for callers that already hold the write lock;
+// clients with a full buffer simply miss the message.
+func (h *Hub) deliverLocked(message []byte, include func(*Client) bool) {
+	for client := range h.Clients {
+		if !include(client) {
+			continue
+		}
+		select {
+		case client.Send <- message:
+		default:
+		}
+	}
+}
+
+// LastHeartbeat is when the Run loop last ticked; zero if it never started.
+// A stale heartbeat means the loop is stuck or has exited.
+func (h *Hub) LastHeartbeat() time.Time {
+	nanos := h.heartbeat.Load()
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// QueueDepth is the number of messages waiting to be broadcast.
+func (h *Hub) QueueDepth() int {
+	return len(h.Broadcast) + len(h.documentBroadcast)
 }
 
 func (h *Hub) GetOnlineCount() int {
@@ -105,11 +377,88 @@ func (h *Hub) GetOnlineCount() int {
 	return len(h.Clients)
 }
 
-func (h *Hub) broadcastUserPresence(userID uuid.UUID, userName, status string) {
+// Presence lists the users connected to a document. A user with several
+// connections is reported once, using the most recently active one.
+func (h *Hub) Presence(documentID uuid.UUID) []models.PresenceUser {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	byUser := make(map[uuid.UUID]models.PresenceUser)
+	for client := range h.Clients {
+		if client.DocumentID != documentID || client.Watcher {
+			continue
+		}
+		if existing, ok := byUser[client.ID]; ok && existing.LastActive.After(client.LastActive) {
+			continue
+		}
+		byUser[client.ID] = client.presence()
+	}
+
+	users := make([]models.PresenceUser, 0, len(byUser))
+	for _, u := range byUser {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].UserName != users[j].UserName {
+			return users[i].UserName < users[j].UserName
+		}
+		return users[i].UserID.String() < users[j].UserID.String()
+	})
+	return users
+}
+
+// presence must be called with the hub lock held.
+func (c *Client) presence() models.PresenceUser {
+	var cursor *models.CursorState
+	if c.Cursor != nil {
+		state := *c.Cursor
+		cursor = &state
+	}
+	return models.PresenceUser{
+		UserID:     c.ID,
+		UserName:   c.Name,
+		Color:      c.Color,
+		Cursor:     cursor,
+		Idle:       time.Since(c.LastActive) > idleAfter,
+		LastActive: c.LastActive,
+	}
+}
+
+// sendPresenceSnapshot tells a newly registered client who is already on its document.
+func (h *Hub) sendPresenceSnapshot(client *Client) {
+	message := models.WebSocketMessage{
+		Type: "presence_snapshot",
+		Data: models.PresenceSnapshot{
+			DocumentID: client.DocumentID,
+			Users:      h.Presence(client.DocumentID),
+		},
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.Clients[client] {
+		return
+	}
+	select {
+	case client.Send <- data:
+	default:
+		client.log.Warn("dropping presence snapshot: send buffer full")
+	}
+}
+
+// broadcastUserPresence tells the other clients of a client's document that
+// it joined or left.
+func (h *Hub) broadcastUserPresence(client *Client, status string) {
 	presence := models.UserPresence{
-		UserID:   userID,
-		UserName: userName,
-		Status:   status,
+		DocumentID: client.DocumentID,
+		UserID:     client.ID,
+		UserName:   client.Name,
+		Status:     status,
 	}
 
 	message := models.WebSocketMessage{
@@ -118,32 +467,164 @@ func (h *Hub) broadcastUserPresence(userID uuid.UUID, userName, status string) {
 	}
 
 	if data, err := json.Marshal(message); err == nil {
-		h.Broadcast <- data
+		h.BroadcastToDocument(client.DocumentID, data)
+	}
+}
+
+// cleanName trims a display name and cuts it to maxNameLength characters,
+// never inside one.
+func cleanName(name string) string {
+	name = strings.TrimSpace(name)
+	if utf8.RuneCountInString(name) > maxNameLength {
+		name = strings.TrimSpace(string([]rune(name)[:maxNameLength]))
 	}
+	return name
 }
 
-func HandleWebSocket(c *gin.Context, hub *Hub) {
-	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+// rename changes a client's display name and tells its document about it.
+func (h *Hub) rename(client *Client, name string) {
+	name = cleanName(name)
+	if name == "" {
+		return
+	}
+
+	h.mu.Lock()
+	client.Name = name
+	update := models.UserUpdate{DocumentID: client.DocumentID, UserID: client.ID, UserName: name, Color: client.Color}
+	h.mu.Unlock()
+
+	message := models.WebSocketMessage{Type: "user_update", Data: update}
+	if data, err := json.Marshal(message); err == nil {
+		h.BroadcastToDocument(client.DocumentID, data)
+	}
+}
+
+// touch records client activity.
+func (h *Hub) touch(client *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	client.LastActive = time.Now()
+}
+
+// moveCursor stores a client's latest cursor and shares it with the other
+// clients of its document.
+func (h *Hub) moveCursor(client *Client, cursor models.CursorState) {
+	h.mu.Lock()
+	client.LastActive = time.Now()
+	client.Cursor = &cursor
+	update := models.CursorUpdate{
+		DocumentID: client.DocumentID,
+		UserID:     client.ID,
+		UserName:   client.Name,
+		Color:      client.Color,
+		Cursor:     cursor,
+	}
+	h.mu.Unlock()
+
+	message := models.WebSocketMessage{Type: "cursor_position", Data: update}
+	if data, err := json.Marshal(message); err == nil {
+		h.BroadcastToDocument(client.DocumentID, data)
+	}
+}
+
+// Cursors returns the latest known cursor of each user on a document.
+func (h *Hub) Cursors(documentID uuid.UUID) map[uuid.UUID]models.CursorState {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	cursors := make(map[uuid.UUID]models.CursorState)
+	for client := range h.Clients {
+		if client.DocumentID == documentID && client.Cursor != nil {
+			cursors[client.ID] = *client.Cursor
+		}
+	}
+	return cursors
+}
+
+// TransformCursors shifts the stored cursors of a document across a committed
+// change so they keep pointing at the same text. The change must already be
+// normalized against the content it was applied to.
+func (h *Hub) TransformCursors(documentID uuid.UUID, change models.TextChange) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for client := range h.Clients {
+		if client.DocumentID != documentID || client.Cursor == nil {
+			continue
+		}
+		// The author's own caret ends up after the text they typed
+		stickRight := client.ID == change.UserID
+		cursor := client.Cursor
+		cursor.Position = textops.TransformPosition(cursor.Position, change, stickRight)
+		cursor.SelectionStart, cursor.SelectionEnd = textops.TransformRange(cursor.SelectionStart, cursor.SelectionEnd, change)
+	}
+}
+
+// colorFor picks a stable color for a user so it is the same on every connection.
+func colorFor(userID uuid.UUID) string {
+	hash := fnv.New32a()
+	hash.Write(userID[:])
+	return presenceColors[hash.Sum32()%uint32(len(presenceColors))]
+}
+
+// HandleWebSocket upgrades the request and registers the client with the hub
+// on documentID, which must be set. userID is the caller's verified user, or
+// uuid.Nil for anonymous callers. Read-only clients receive updates but
+// do not take chain turns.
+func HandleWebSocket(c *gin.Context, hub *Hub, documentID, userID uuid.UUID, readOnly bool) {
+	if documentID == uuid.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+	conn, err := hub.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		logging.FromContext(c, hub.log).Warn("websocket upgrade failed",
+			"origin", c.GetHeader("Origin"),
+			"error", err,
+		)
 		return
 	}
 
-	userID := uuid.New()
-	userName := c.Query("name")
+	// Clients with a session keep its user so presence matches change
+	// authorship; anonymous ones get an ID for this connection only
+	anonymous := userID == uuid.Nil
+	if anonymous {
+		userID = uuid.New()
+	}
+	userName := cleanName(c.Query("name"))
 	if userName == "" {
 		userName = "Anonymous"
 	}
 
+	requestID := logging.GetRequestID(c)
 	client := &Client{
-		ID:   userID,
-		Name: userName,
-		Conn: conn,
-		Send: make(chan []byte, 256),
-		Hub:  hub,
+		ID:         userID,
+		Name:       userName,
+		Color:      colorFor(userID),
+		DocumentID: documentID,
+		Conn:       conn,
+		Send:       make(chan []byte, hub.cfg.WebSocket.SendBuffer),
+		Hub:        hub,
+		LastActive: time.Now(),
+		JoinedAt:   time.Now(),
+		RequestID:  requestID,
+		IP:         c.ClientIP(),
+		ReadOnly:   readOnly,
+		Anonymous:  anonymous,
+		log: hub.log.With(
+			"request_id", requestID,
+			"user_id", userID,
+			"document_id", documentID,
+		),
 	}
 
-	hub.Register <- client
+	select {
+	case hub.Register <- client:
+	case <-hub.done:
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		conn.Close()
+		return
+	}
 
 	go client.writePump()
 	go client.readPump()
@@ -151,14 +632,18 @@ func HandleWebSocket(c *gin.Context, hub *Hub) {
 
 func (c *Client) readPump() {
 	defer func() {
-		c.Hub.Unregister <- c
+		select {
+		case c.Hub.Unregister <- c:
+		case <-c.Hub.done:
+		}
 		c.Conn.Close()
 	}()
 
-	c.Conn.SetReadLimit(512)
-	c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+	cfg := c.Hub.cfg.WebSocket
+	c.Conn.SetReadLimit(c.Hub.cfg.Policy.MaxMessageBytes)
+	c.Conn.SetReadDeadline(time.Now().Add(cfg.PongWait.Duration))
 	c.Conn.SetPongHandler(func(string) error {
-		c.Conn.SetReadDeadline(time.Now().Add(60 * time.Second))
+		c.Conn.SetReadDeadline(time.Now().Add(cfg.PongWait.Duration))
 		return nil
 	})
 
@@ -168,26 +653,50 @@ func (c *Client) readPump() {
 			break
 		}
 
-		var wsMessage models.WebSocketMessage
+		var wsMessage inboundMessage
 		if err := json.Unmarshal(message, &wsMessage); err != nil {
 			continue
 		}
+		if !c.Hub.limiter.AllowMessage(context.Background(), c.ID, c.IP, wsMessage.Type) {
+			continue
+		}
 
 		switch wsMessage.Type {
-		case "text_change":
-			if time.Now().Before(c.Cooldown) {
+		// Edits are not relayed: they go through PUT /api/document/:id, which
+		// checks roles, policy and locks and broadcasts what it commits
+		case "cursor_position":
+			var cursor struct {
+				Position       *int `json:"position"`
+				SelectionStart *int `json:"selection_start"`
+				SelectionEnd   *int `json:"selection_end"`
+			}
+			if err := json.Unmarshal(wsMessage.Data, &cursor); err != nil || cursor.Position == nil {
 				continue
 			}
-			c.Cooldown = time.Now().Add(10 * time.Second)
-			c.Hub.Broadcast <- message
-		case "cursor_position":
-			c.Hub.Broadcast <- message
+			state := models.CursorState{Position: max(*cursor.Position, 0)}
+			state.SelectionStart, state.SelectionEnd = state.Position, state.Position
+			if cursor.SelectionStart != nil && cursor.SelectionEnd != nil {
+				state.SelectionStart = max(min(*cursor.SelectionStart, *cursor.SelectionEnd), 0)
+				state.SelectionEnd = max(*cursor.SelectionStart, *cursor.SelectionEnd, 0)
+			}
+			c.Hub.moveCursor(c, state)
+		case "user_update":
+			var update struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(wsMessage.Data, &update); err != nil {
+				continue
+			}
+			c.Hub.touch(c)
+			c.Hub.rename(c, update.Name)
 		}
 	}
 }
 
 func (c *Client) writePump() {
-	ticker := time.NewTicker(54 * time.Second)
+	cfg := c.Hub.cfg.WebSocket
+	// Ping before the peer's read deadline (PongWait) runs out
+	ticker := time.NewTicker(cfg.PongWait.Duration * 9 / 10)
 	defer func() {
 		ticker.Stop()
 		c.Conn.Close()
@@ -196,29 +705,30 @@ func (c *Client) writePump() {
 	for {
 		select {
 		case message, ok := <-c.Send:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait.Duration))
 			if !ok {
-				c.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+				closeMessage := []byte{}
+				if c.Hub.stopping.Load() {
+					closeMessage = websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				}
+				c.Conn.WriteMessage(websocket.CloseMessage, closeMessage)
 				return
 			}
 
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
+			if err := c.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				return
 			}
-			w.Write(message)
 
+			// Flush queued messages as separate frames so each one stays valid JSON
 			n := len(c.Send)
 			for i := 0; i < n; i++ {
-				w.Write(<-c.Send)
-			}
-
-			if err := w.Close(); err != nil {
-				return
+				if err := c.Conn.WriteMessage(websocket.TextMessage, <-c.Send); err != nil {
+					return
+				}
 			}
 
 		case <-ticker.C:
-			c.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			c.Conn.SetWriteDeadline(time.Now().Add(cfg.WriteWait.Duration))
 			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -0,0 +1,272 @@
+package websocket
+
+import (
+	"encoding/json"
+	"slices"
+	"sort"
+	"time"
+
+	"storychain-backend/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// chain tracks turn order for a document in story chain mode. Participants
+// are the users connected to the document, in the order they joined.
+type chain struct {
+	turn     time.Duration
+	order    []uuid.UUID
+	current  int // index into order, -1 while nobody is connected
+	deadline time.Time
+	timer    *time.Timer
+	// seq changes with every turn so a timer that fires late does nothing
+	seq uint64
+}
+
+// SetChain turns story chain mode on or off for a document. Changing the turn
+// length of an active chain takes effect from the next turn.
+func (h *Hub) SetChain(documentID uuid.UUID, enabled bool, turn time.Duration) {
+	h.mu.Lock()
+	ch, ok := h.chains[documentID]
+	switch {
+	case !enabled && !ok:
+		h.mu.Unlock()
+		return
+	case !enabled:
+		if ch.timer != nil {
+			ch.timer.Stop()
+		}
+		delete(h.chains, documentID)
+	case ok:
+		ch.turn = turn
+	default:
+		ch = &chain{turn: turn, current: -1, order: h.documentUsersLocked(documentID)}
+		h.chains[documentID] = ch
+		h.startTurnLocked(documentID, ch, 0)
+	}
+	state := h.turnStateLocked(documentID)
+	h.mu.Unlock()
+
+	h.broadcastTurn(state)
+}
+
+// Turn reports the turn state of a document; Active is false outside chain mode.
+func (h *Hub) Turn(documentID uuid.UUID) models.TurnState {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.turnStateLocked(documentID)
+}
+
+// TakeTurn uses up userID's turn on a chain-mode document and passes it on.
+// It reports false, with the current state, when it is someone else's turn.
+// Documents outside chain mode always allow the edit. The turn is taken
+// before the edit is saved so two concurrent edits cannot both use it; if
+// the edit then fails to save, the returned function gives the turn back.
+func (h *Hub) TakeTurn(documentID, userID uuid.UUID) (models.TurnState, func(), bool) {
+	h.mu.Lock()
+	ch, ok := h.chains[documentID]
+	if !ok {
+		h.mu.Unlock()
+		return models.TurnState{DocumentID: documentID, Participants: []uuid.UUID{}}, func() {}, true
+	}
+	if ch.current < 0 || ch.order[ch.current] != userID {
+		state := h.turnStateLocked(documentID)
+		h.mu.Unlock()
+		return state, func() {}, false
+	}
+	h.startTurnLocked(documentID, ch, ch.current+1)
+	seq := ch.seq
+	state := h.turnStateLocked(documentID)
+	h.mu.Unlock()
+
+	h.broadcastTurn(state)
+	return state, func() { h.returnTurn(documentID, userID, seq) }, true
+}
+
+// returnTurn gives userID a fresh turn again after an edit that used it
+// failed, unless the turn has moved on since it was taken at seq.
+func (h *Hub) returnTurn(documentID, userID uuid.UUID, seq uint64) {
+	h.mu.Lock()
+	ch, ok := h.chains[documentID]
+	if !ok || ch.seq != seq {
+		h.mu.Unlock()
+		return
+	}
+	i := slices.Index(ch.order, userID)
+	if i < 0 {
+		h.mu.Unlock()
+		return
+	}
+	h.startTurnLocked(documentID, ch, i)
+	state := h.turnStateLocked(documentID)
+	h.mu.Unlock()
+
+	h.broadcastTurn(state)
+}
+
+// inChain reports whether a document is in story chain mode.
+func (h *Hub) inChain(documentID uuid.UUID) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, ok := h.chains[documentID]
+	return ok
+}
+
+// takesTurns reports whether a client's user is part of the turn order.
+// Read-only clients and anonymous ones, whose turns could never be used,
+// watch without taking turns.
+func (c *Client) takesTurns() bool {
+	return !c.ReadOnly && !c.Anonymous
+}
+
+// joinChain adds a newly registered client's user to the turn order.
+func (h *Hub) joinChain(client *Client) {
+	h.mu.Lock()
+	ch, ok := h.chains[client.DocumentID]
+	if !ok || !client.takesTurns() {
+		h.mu.Unlock()
+		return
+	}
+	if !slices.Contains(ch.order, client.ID) {
+		ch.order = append(ch.order, client.ID)
+		if ch.current < 0 {
+			h.startTurnLocked(client.DocumentID, ch, 0)
+		}
+	}
+	state := h.turnStateLocked(client.DocumentID)
+	h.mu.Unlock()
+
+	h.broadcastTurn(state)
+}
+
+// leaveChain drops a user from the turn order once their last connection to
+// the document is gone, passing the turn on if it was theirs.
+func (h *Hub) leaveChain(client *Client) {
+	h.mu.Lock()
+	ch, ok := h.chains[client.DocumentID]
+	if !ok {
+		h.mu.Unlock()
+		return
+	}
+	for other := range h.Clients {
+		if other.DocumentID == client.DocumentID && other.ID == client.ID && other.takesTurns() {
+			h.mu.Unlock()
+			return
+		}
+	}
+	i := slices.Index(ch.order, client.ID)
+	if i < 0 {
+		h.mu.Unlock()
+		return
+	}
+	ch.order = slices.Delete(ch.order, i, i+1)
+	switch {
+	case i < ch.current:
+		ch.current--
+	case i == ch.current:
+		// The next participant has moved into the same slot
+		h.startTurnLocked(client.DocumentID, ch, ch.current)
+	}
+	state := h.turnStateLocked(client.DocumentID)
+	h.mu.Unlock()
+
+	h.broadcastTurn(state)
+}
+
+// expireTurn passes the turn on when its holder ran out of time.
+func (h *Hub) expireTurn(documentID uuid.UUID, seq uint64) {
+	if h.stopping.Load() {
+		return
+	}
+	h.mu.Lock()
+	ch, ok := h.chains[documentID]
+	if !ok || ch.seq != seq {
+		h.mu.Unlock()
+		return
+	}
+	h.startTurnLocked(documentID, ch, ch.current+1)
+	state := h.turnStateLocked(documentID)
+	h.mu.Unlock()
+
+	h.broadcastTurn(state)
+}
+
+// startTurnLocked gives the turn to the participant at index, wrapping
+// around, and restarts the turn timer. The hub lock must be held.
+func (h *Hub) startTurnLocked(documentID uuid.UUID, ch *chain, index int) {
+	ch.seq++
+	if ch.timer != nil {
+		ch.timer.Stop()
+		ch.timer = nil
+	}
+	if len(ch.order) == 0 {
+		ch.current = -1
+		ch.deadline = time.Time{}
+		return
+	}
+
+	ch.current = index % len(ch.order)
+	ch.deadline = time.Now().Add(ch.turn)
+	seq := ch.seq
+	ch.timer = time.AfterFunc(ch.turn, func() { h.expireTurn(documentID, seq) })
+}
+
+// documentUsersLocked lists the users who can take turns on a document
+// over their connections, in the order they joined. The hub lock must be held.
+func (h *Hub) documentUsersLocked(documentID uuid.UUID) []uuid.UUID {
+	joined := make(map[uuid.UUID]time.Time)
+	for client := range h.Clients {
+		if client.DocumentID != documentID || !client.takesTurns() {
+			continue
+		}
+		if at, ok := joined[client.ID]; !ok || client.JoinedAt.Before(at) {
+			joined[client.ID] = client.JoinedAt
+		}
+	}
+
+	users := make([]uuid.UUID, 0, len(joined))
+	for userID := range joined {
+		users = append(users, userID)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if !joined[users[i]].Equal(joined[users[j]]) {
+			return joined[users[i]].Before(joined[users[j]])
+		}
+		return users[i].String() < users[j].String()
+	})
+	return users
+}
+
+// turnStateLocked must be called with the hub lock held.
+func (h *Hub) turnStateLocked(documentID uuid.UUID) models.TurnState {
+	state := models.TurnState{DocumentID: documentID, Participants: []uuid.UUID{}}
+	ch, ok := h.chains[documentID]
+	if !ok {
+		return state
+	}
+
+	state.Active = true
+	state.TurnSeconds = int(ch.turn.Seconds())
+	state.Participants = append(state.Participants, ch.order...)
+	if ch.current >= 0 {
+		userID := ch.order[ch.current]
+		deadline := ch.deadline
+		state.UserID = &userID
+		state.Deadline = &deadline
+		for client := range h.Clients {
+			if client.DocumentID == documentID && client.ID == userID {
+				state.UserName = client.Name
+				break
+			}
+		}
+	}
+	return state
+}
+
+// broadcastTurn tells the clients of a document whose turn it is.
+func (h *Hub) broadcastTurn(state models.TurnState) {
+	message := models.WebSocketMessage{Type: "turn", Data: state}
+	if data, err := json.Marshal(message); err == nil {
+		h.BroadcastToDocument(state.DocumentID, data)
+	}
+}
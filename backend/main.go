@@ -1,14 +1,27 @@
 package main
 
 import (
-	"log"
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"storychain-backend/internal/config"
 	"storychain-backend/internal/database"
 	"storychain-backend/internal/handlers"
+	"storychain-backend/internal/health"
+	"storychain-backend/internal/logging"
+	"storychain-backend/internal/metrics"
+	"storychain-backend/internal/moderation"
+	"storychain-backend/internal/origin"
+	"storychain-backend/internal/ratelimit"
 	"storychain-backend/internal/websocket"
 
 	"github.com/gin-gonic/gin"
@@ -17,49 +30,96 @@ import (
 
 var startTime = time.Now()
 
+// hubStaleAfter is how old the hub heartbeat may get before the hub counts as stuck.
+const hubStaleAfter = 30 * time.Second
+
 func main() {
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found")
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration and exit")
+	flag.Parse()
+
+	envErr := godotenv.Load()
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		out, err := cfg.Redacted()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
 	}
 
-	cfg := config.Load()
+	logger := logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	// Route the standard library logger through slog as well
+	slog.SetDefault(logger)
+
+	if envErr != nil {
+		logger.Info("no .env file found")
+	}
 
-	db, err := database.Connect(cfg.DatabaseURL)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	db, err := database.Connect(cfg.Database)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		logger.Error("failed to connect to database", "error", err)
+		os.Exit(1)
 	}
 	defer db.Close()
 
 	if err := database.RunMigrations(db); err != nil {
-		log.Printf("Migration error: %v", err)
+		logger.Error("migration error", "error", err)
 	}
 
-	hub := websocket.NewHub()
-	go hub.Run()
+	// Config validation already checked the origins, so this cannot fail
+	origins, _ := origin.NewPolicy(cfg.Server.Origins())
 
-	r := gin.Default()
+	var limitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimit.Store == "postgres" {
+		limitStore = ratelimit.NewPostgresStore(db)
+	}
+	limiter := ratelimit.NewLimiter(cfg.RateLimit, limitStore, logger)
 
-	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", cfg.FrontendURL)
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		c.Header("Access-Control-Allow-Credentials", "true")
+	hub := websocket.NewHub(cfg, origins, limiter, logger)
+	hubCtx, stopHub := context.WithCancel(context.Background())
+	defer stopHub()
+	go hub.Run(hubCtx)
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	moderator := moderation.NewClient(cfg.Moderation.Endpoint, cfg.Moderation.Timeout.Duration)
 
-		c.Next()
-	})
+	metrics.RegisterDB(db)
+	metrics.RegisterBroadcastQueue(hub.QueueDepth)
+
+	r := gin.New()
+	r.TrustedPlatform = trustedPlatformHeader(cfg.Server.TrustedPlatform)
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Error("invalid trusted proxies", "error", err)
+		os.Exit(1)
+	}
+	r.Use(gin.Recovery())
+	r.Use(logging.RequestID(logger))
+	r.Use(metrics.Middleware())
+
+	r.Use(origins.CORS(
+		[]string{"Content-Type", "Authorization", logging.RequestIDHeader, handlers.SessionHeader, handlers.InviteTokenHeader, handlers.APIKeyHeader},
+		[]string{logging.RequestIDHeader, "Retry-After"},
+	))
 
 	r.GET("/status", func(c *gin.Context) {
 		uptime := time.Since(startTime).Seconds()
 		dbStatus := "ok"
+		code := http.StatusOK
 		if err := db.PingContext(c.Request.Context()); err != nil {
 			dbStatus = "error"
+			code = http.StatusServiceUnavailable
 		}
-		c.JSON(http.StatusOK, gin.H{
+		c.JSON(code, gin.H{
 			"status":         "ok",
 			"uptime_seconds": uptime,
 			"timestamp":      time.Now().UTC().Format(time.RFC3339),
@@ -67,14 +127,116 @@ func main() {
 		})
 	})
 
+	liveness, readiness := healthCheckers(cfg, db, hub, moderator)
+	r.GET("/healthz", health.Handler(liveness))
+	r.GET("/readyz", health.Handler(readiness))
+	r.GET("/metrics", metrics.Handler())
+
 	api := r.Group("/api")
-	handlers.SetupRoutes(api, db, hub)
+	handler := handlers.SetupRoutes(api, cfg, db, hub, moderator, limiter, logger)
+	go handler.SampleActivity(hubCtx)
+	go handler.DeliverWebhooks(hubCtx)
+	go handler.BroadcastStats(hubCtx)
+
+	srv := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Server.Port),
+		Handler:           r,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout.Duration,
+	}
+	// Event streams never finish on their own, so end them as shutdown starts
+	srv.RegisterOnShutdown(handler.StopStreams)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("server starting", "port", cfg.Server.Port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("server stopped", "error", err)
+			os.Exit(1)
+		}
+	case <-ctx.Done():
+		stop()
+		logger.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout.Duration)
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout.Duration)
+	defer cancel()
+
+	// Stop accepting requests and let in-flight ones such as updateDocument finish
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Warn("http shutdown incomplete", "error", err)
+	}
+	// Moderation may still revert and broadcast, so drain it while the hub runs
+	if err := handler.Drain(shutdownCtx); err != nil {
+		logger.Warn("background work cancelled", "error", err)
+	}
+	stopHub()
+	select {
+	case <-hub.Done():
+	case <-shutdownCtx.Done():
+		logger.Warn("hub did not stop in time")
+	}
+	logger.Info("shutdown complete")
+}
+
+// trustedPlatformHeader maps server.trusted_platform to the header gin reads
+// the client IP from.
+func trustedPlatformHeader(platform string) string {
+	switch platform {
+	case "flyio":
+		return gin.PlatformFlyIO
+	case "cloudflare":
+		return gin.PlatformCloudflare
+	case "appengine":
+		return gin.PlatformGoogleAppEngine
+	}
+	return ""
+}
+
+// healthCheckers builds the liveness checks, which only fail when a restart
+// would help, and the readiness checks, which also cover dependencies.
+func healthCheckers(cfg *config.Config, db *sql.DB, hub *websocket.Hub, moderator *moderation.Client) (*health.Checker, *health.Checker) {
+	hubCheck := func(context.Context) error {
+		beat := hub.LastHeartbeat()
+		if beat.IsZero() {
+			return fmt.Errorf("hub loop not started")
+		}
+		if age := time.Since(beat); age > hubStaleAfter {
+			return fmt.Errorf("hub heartbeat is %s old", age.Round(time.Second))
+		}
+		return nil
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
+	liveness := health.NewChecker(2 * time.Second)
+	liveness.Add("hub", true, hubCheck)
+
+	readiness := health.NewChecker(3 * time.Second)
+	readiness.Add("database", true, db.PingContext)
+	readiness.Add("migrations", true, func(context.Context) error {
+		version, dirty, err := database.MigrationVersion(db)
+		if err != nil {
+			return err
+		}
+		if dirty {
+			return fmt.Errorf("migration %d is dirty", version)
+		}
+		latest, err := database.LatestMigration(database.MigrationsDir)
+		if err != nil {
+			return err
+		}
+		if version < latest {
+			return fmt.Errorf("database at version %d, want %d", version, latest)
+		}
+		return nil
+	})
+	readiness.Add("hub", true, hubCheck)
+	if cfg.Moderation.Enabled {
+		// Moderation failures never block edits, so they only degrade readiness
+		readiness.Add("moderation", false, moderator.Ping)
+	}
+	return liveness, readiness
 }
@@ -0,0 +1,3 @@
+ALTER TABLE documents DROP COLUMN IF EXISTS turn_seconds;
+ALTER TABLE documents DROP COLUMN IF EXISTS chain_unit;
+ALTER TABLE documents DROP COLUMN IF EXISTS chain_mode;
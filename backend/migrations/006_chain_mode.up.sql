@@ -0,0 +1,4 @@
+-- Story chain mode: contributors take turns adding one word or sentence
+ALTER TABLE documents ADD COLUMN chain_mode BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE documents ADD COLUMN chain_unit VARCHAR(20) NOT NULL DEFAULT 'word';
+ALTER TABLE documents ADD COLUMN turn_seconds INTEGER NOT NULL DEFAULT 60;
@@ -0,0 +1,12 @@
+-- Read-only ranges of a document; offsets are shifted as the text around them changes
+CREATE TABLE document_locks (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    start_pos INTEGER NOT NULL,
+    end_pos INTEGER NOT NULL,
+    label VARCHAR(100) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (start_pos >= 0 AND end_pos > start_pos)
+);
+
+CREATE INDEX idx_document_locks_document_id ON document_locks(document_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS document_roles;
+ALTER TABLE documents DROP COLUMN IF EXISTS default_role;
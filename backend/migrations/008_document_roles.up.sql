@@ -0,0 +1,12 @@
+-- default_role applies to anyone without an explicit role, including anonymous users
+ALTER TABLE documents ADD COLUMN default_role VARCHAR(20) NOT NULL DEFAULT 'editor';
+
+CREATE TABLE document_roles (
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    role VARCHAR(20) NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (document_id, user_id)
+);
+
+CREATE INDEX idx_document_roles_user_id ON document_roles(user_id);
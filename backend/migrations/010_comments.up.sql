@@ -0,0 +1,16 @@
+-- Comments anchored to a range of a document; offsets shift as the text changes
+CREATE TABLE comments (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    start_pos INTEGER NOT NULL,
+    end_pos INTEGER NOT NULL,
+    author_id UUID NOT NULL,
+    author_name VARCHAR(255) NOT NULL,
+    body TEXT NOT NULL,
+    resolved BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CHECK (start_pos >= 0 AND end_pos >= start_pos)
+);
+
+CREATE INDEX idx_comments_document_id ON comments(document_id);
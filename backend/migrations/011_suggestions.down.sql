@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS suggestions;
+DROP INDEX IF EXISTS idx_changes_document_revision;
+ALTER TABLE changes DROP COLUMN IF EXISTS revision;
+ALTER TABLE documents DROP COLUMN IF EXISTS revision;
@@ -0,0 +1,24 @@
+-- Every committed change bumps the document revision and records it
+ALTER TABLE documents ADD COLUMN revision BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE changes ADD COLUMN revision BIGINT;
+CREATE INDEX idx_changes_document_revision ON changes(document_id, revision);
+
+-- Proposed changes, kept rebased onto the latest revision while pending
+CREATE TABLE suggestions (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    author_id UUID NOT NULL,
+    author_name VARCHAR(255) NOT NULL,
+    change_type VARCHAR(50) NOT NULL,
+    content TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    length INTEGER NOT NULL DEFAULT 0,
+    base_revision BIGINT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending', -- 'pending', 'accepted', 'rejected', 'outdated'
+    change_id UUID,
+    resolved_by UUID,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_suggestions_document_status ON suggestions(document_id, status);
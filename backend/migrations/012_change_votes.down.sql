@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS change_votes;
+ALTER TABLE changes DROP COLUMN IF EXISTS reverted_by;
+ALTER TABLE changes DROP COLUMN IF EXISTS removed;
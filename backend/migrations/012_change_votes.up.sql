@@ -0,0 +1,14 @@
+-- Text each change removed, so any change can later be reverted by its inverse.
+-- Changes recorded before this migration keep an empty value.
+ALTER TABLE changes ADD COLUMN removed TEXT NOT NULL DEFAULT '';
+-- The change that undid this one, if any
+ALTER TABLE changes ADD COLUMN reverted_by UUID;
+
+-- One up (+1) or down (-1) vote per user and change
+CREATE TABLE change_votes (
+    change_id UUID NOT NULL REFERENCES changes(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    value SMALLINT NOT NULL CHECK (value IN (-1, 1)),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (change_id, user_id)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_changes_search;
+DROP INDEX IF EXISTS idx_documents_search;
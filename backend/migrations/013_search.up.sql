@@ -0,0 +1,3 @@
+-- Full-text indexes for /api/search; queries must use the same expressions
+CREATE INDEX idx_documents_search ON documents USING GIN (to_tsvector('english', content));
+CREATE INDEX idx_changes_search ON changes USING GIN (to_tsvector('english', content));
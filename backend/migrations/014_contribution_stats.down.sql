@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS contribution_days;
+DROP TABLE IF EXISTS contribution_stats;
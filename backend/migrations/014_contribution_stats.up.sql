@@ -0,0 +1,47 @@
+-- Per-user, per-document totals, kept up to date as changes commit
+CREATE TABLE contribution_stats (
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL,
+    user_name VARCHAR(255) NOT NULL,
+    inserts INTEGER NOT NULL DEFAULT 0,
+    deletes INTEGER NOT NULL DEFAULT 0,
+    replaces INTEGER NOT NULL DEFAULT 0,
+    chars_added BIGINT NOT NULL DEFAULT 0,
+    chars_removed BIGINT NOT NULL DEFAULT 0,
+    reverted INTEGER NOT NULL DEFAULT 0, -- changes undone by moderation or votes
+    first_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    last_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (document_id, user_id)
+);
+
+CREATE INDEX idx_contribution_stats_user_id ON contribution_stats(user_id);
+
+-- Days (UTC) each user contributed to each document, for streaks
+CREATE TABLE contribution_days (
+    user_id UUID NOT NULL,
+    document_id UUID NOT NULL REFERENCES documents(id) ON DELETE CASCADE,
+    day DATE NOT NULL,
+    PRIMARY KEY (user_id, document_id, day)
+);
+
+-- Backfill from the existing history; system changes have the nil user.
+-- Text is counted in characters. Changes from before removed text was kept
+-- only have the removed length, in bytes.
+INSERT INTO contribution_stats (document_id, user_id, user_name, inserts, deletes, replaces, chars_added, chars_removed, reverted, first_at, last_at)
+SELECT document_id, user_id,
+    (ARRAY_AGG(user_name ORDER BY timestamp DESC))[1],
+    COUNT(*) FILTER (WHERE change_type = 'insert'),
+    COUNT(*) FILTER (WHERE change_type = 'delete'),
+    COUNT(*) FILTER (WHERE change_type = 'replace'),
+    COALESCE(SUM(CHAR_LENGTH(content)) FILTER (WHERE change_type IN ('insert', 'replace')), 0),
+    COALESCE(SUM(CASE WHEN removed <> '' THEN CHAR_LENGTH(removed) ELSE length END) FILTER (WHERE change_type IN ('delete', 'replace')), 0),
+    COUNT(*) FILTER (WHERE reverted_by IS NOT NULL),
+    MIN(timestamp), MAX(timestamp)
+FROM changes
+WHERE user_id <> '00000000-0000-0000-0000-000000000000'
+GROUP BY document_id, user_id;
+
+INSERT INTO contribution_days (user_id, document_id, day)
+SELECT DISTINCT user_id, document_id, (timestamp AT TIME ZONE 'UTC')::date
+FROM changes
+WHERE user_id <> '00000000-0000-0000-0000-000000000000';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_changes_document_timestamp;
+DROP TABLE IF EXISTS activity_samples;
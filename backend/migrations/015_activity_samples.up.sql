@@ -0,0 +1,10 @@
+-- Peak users connected at once between samples; a NULL document_id is the
+-- whole instance. Each instance writes its own rows.
+CREATE TABLE activity_samples (
+    sampled_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    document_id UUID,
+    peak_users INTEGER NOT NULL
+);
+
+CREATE INDEX idx_activity_samples_document_time ON activity_samples(document_id, sampled_at);
+CREATE INDEX idx_changes_document_timestamp ON changes(document_id, timestamp);
@@ -0,0 +1 @@
+ALTER TABLE activity_samples DROP COLUMN IF EXISTS instance_id;
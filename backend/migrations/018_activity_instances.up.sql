@@ -0,0 +1,3 @@
+-- The instance that wrote each sample, so instances sampling more than once
+-- a minute are not added up with themselves. Older samples have none.
+ALTER TABLE activity_samples ADD COLUMN instance_id UUID;
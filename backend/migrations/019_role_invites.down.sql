@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_document_roles_invite_id;
+ALTER TABLE document_roles DROP COLUMN IF EXISTS invite_id;
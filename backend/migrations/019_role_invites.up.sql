@@ -0,0 +1,5 @@
+-- The invite a role was granted through, so revoking the invite can take
+-- the role back. Roles set by an owner have none.
+ALTER TABLE document_roles ADD COLUMN invite_id UUID REFERENCES document_invites(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_document_roles_invite_id ON document_roles(invite_id);
@@ -0,0 +1,2 @@
+-- Character counts are kept; byte counts are not restored.
+SELECT 1;
@@ -0,0 +1,15 @@
+-- Recount text added and removed in characters rather than bytes, for
+-- databases whose contribution_stats were backfilled in bytes. Changes from
+-- before removed text was kept only have the removed length, in bytes.
+UPDATE contribution_stats s SET
+    chars_added = totals.added,
+    chars_removed = totals.removed
+FROM (
+    SELECT document_id, user_id,
+        COALESCE(SUM(CHAR_LENGTH(content)) FILTER (WHERE change_type IN ('insert', 'replace')), 0) AS added,
+        COALESCE(SUM(CASE WHEN removed <> '' THEN CHAR_LENGTH(removed) ELSE length END) FILTER (WHERE change_type IN ('delete', 'replace')), 0) AS removed
+    FROM changes
+    WHERE user_id <> '00000000-0000-0000-0000-000000000000'
+    GROUP BY document_id, user_id
+) totals
+WHERE s.document_id = totals.document_id AND s.user_id = totals.user_id;
@@ -0,0 +1 @@
+ALTER TABLE document_roles DROP COLUMN IF EXISTS prior_role;
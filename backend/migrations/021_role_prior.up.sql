@@ -0,0 +1,3 @@
+-- The explicit role a user had before an invite raised it, restored when
+-- the invite is revoked. NULL when the invite created the role.
+ALTER TABLE document_roles ADD COLUMN prior_role VARCHAR(20);
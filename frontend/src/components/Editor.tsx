@@ -91,7 +91,6 @@ export default function Editor({ isLoading }: EditorProps) {
         content: toInsert,
         position: editingPosition,
         length: originalContent.length,
-        user_id: (currentUser?.id && currentUser.id.length === 36) ? currentUser.id : '00000000-0000-0000-0000-000000000000',
         user_name: currentUser?.name || 'Anonymous'
       }
 
@@ -70,7 +70,7 @@ export default function Layout() {
       clearInterval(checkConnection)
       websocketService.disconnect()
     }
-  }, [currentUser?.name])
+  }, [currentUser?.name, documentId])
 
   useEffect(() => {
     const updateStatsInterval = setInterval(async () => {
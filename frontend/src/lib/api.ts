@@ -5,8 +5,78 @@ const API_BASE_URL =
     ? `${window.location.protocol}//${window.location.hostname}:8080`
     : 'http://localhost:8080')
 
+export type Session = {
+  user_id: string
+  token: string
+  expires_at: string
+}
+
+const SESSION_KEY = 'storychain-session'
+let pendingSession: Promise<Session> | null = null
+
+function storedSession(): Session | null {
+  try {
+    const stored = localStorage.getItem(SESSION_KEY)
+    if (!stored) return null
+    const session = JSON.parse(stored) as Session
+    // Renew a little early so a request never goes out with an expired token
+    if (new Date(session.expires_at).getTime() - Date.now() < 24 * 60 * 60 * 1000) {
+      return null
+    }
+    return session
+  } catch {
+    return null
+  }
+}
+
+// getSession returns this browser's session, starting one on first use.
+// The server issues the user ID, so it cannot be claimed by anyone else.
+export function getSession(): Promise<Session> {
+  const stored = storedSession()
+  if (stored) return Promise.resolve(stored)
+
+  if (!pendingSession) {
+    let previous: string | null = null
+    try {
+      previous = localStorage.getItem(SESSION_KEY)
+    } catch {}
+    const headers: Record<string, string> = {}
+    if (previous) {
+      // Renewing keeps the same user ID; an expired token would be refused
+      try {
+        const session = JSON.parse(previous) as Session
+        if (new Date(session.expires_at).getTime() > Date.now()) {
+          headers['X-Session-Token'] = session.token
+        }
+      } catch {}
+    }
+    pendingSession = fetch(`${API_BASE_URL}/api/session`, { method: 'POST', headers })
+      .then(async (response) => {
+        if (!response.ok) {
+          throw new Error('Failed to start session')
+        }
+        const session = (await response.json()) as Session
+        try {
+          localStorage.setItem(SESSION_KEY, JSON.stringify(session))
+        } catch {}
+        return session
+      })
+      .finally(() => {
+        pendingSession = null
+      })
+  }
+  return pendingSession
+}
+
+async function sessionHeaders(): Promise<Record<string, string>> {
+  const session = await getSession()
+  return { 'X-Session-Token': session.token }
+}
+
 export async function fetchDocument(documentId: string) {
-  const response = await fetch(`${API_BASE_URL}/api/document/${documentId}`)
+  const response = await fetch(`${API_BASE_URL}/api/document/${documentId}`, {
+    headers: await sessionHeaders(),
+  })
   if (!response.ok) {
     throw new Error('Failed to fetch document')
   }
@@ -19,7 +89,6 @@ export type ChangePayload = {
   content: string
   position: number
   length: number
-  user_id: string
   user_name: string
 }
 
@@ -28,6 +97,7 @@ export async function updateDocument(documentId: string, change: ChangePayload)
     method: 'PUT',
     headers: {
       'Content-Type': 'application/json',
+      ...(await sessionHeaders()),
     },
     body: JSON.stringify(change),
   })
@@ -41,7 +111,9 @@ export async function updateDocument(documentId: string, change: ChangePayload)
 }
 
 export async function fetchChanges(documentId: string) {
-  const response = await fetch(`${API_BASE_URL}/api/changes/${documentId}`)
+  const response = await fetch(`${API_BASE_URL}/api/changes/${documentId}`, {
+    headers: await sessionHeaders(),
+  })
   if (!response.ok) {
     throw new Error('Failed to fetch changes')
   }
@@ -49,7 +121,9 @@ export async function fetchChanges(documentId: string) {
 }
 
 export async function fetchStats() {
-  const response = await fetch(`${API_BASE_URL}/api/stats`)
+  const response = await fetch(`${API_BASE_URL}/api/stats`, {
+    headers: await sessionHeaders(),
+  })
   if (!response.ok) {
     throw new Error('Failed to fetch stats')
   }
@@ -1,4 +1,5 @@
 import { useStore, type Stats as AppStats } from '@/stores/useStore'
+import { getSession, type Session } from '@/lib/api'
 
 type UserPresence = { userID: string; userName: string; status: string }
 
@@ -25,34 +26,30 @@ class WebSocketService {
   private reconnectAttempts = 0
   private maxReconnectAttempts = 5
   private processedChangeIds = new Set<string>()
+  private connecting = false
 
-  private generateUuid(): string {
-    // Prefer secure, standards-based UUID
-    const c: Crypto | undefined = typeof globalThis !== 'undefined' ? (globalThis as unknown as { crypto?: Crypto }).crypto : undefined
-    if (c?.randomUUID) {
-      return c.randomUUID()
-    }
-    // Fallback: UUID v4 using crypto.getRandomValues
-    if (c?.getRandomValues) {
-      const bytes = new Uint8Array(16)
-      c.getRandomValues(bytes)
-      // Per RFC 4122 version 4
-      bytes[6] = (bytes[6] & 0x0f) | 0x40
-      bytes[8] = (bytes[8] & 0x3f) | 0x80
-      const hex = Array.from(bytes).map(b => b.toString(16).padStart(2, '0'))
-      return (
-        hex.slice(0, 4).join('') + '-' +
-        hex.slice(4, 6).join('') + '-' +
-        hex.slice(6, 8).join('') + '-' +
-        hex.slice(8, 10).join('') + '-' +
-        hex.slice(10, 16).join('')
-      )
+  connect(userName: string = 'Anonymous') {
+    // Prevent duplicate connections
+    if (this.connecting) return
+    if (this.socket) {
+      if (this.socket.readyState === WebSocket.OPEN || this.socket.readyState === WebSocket.CONNECTING) {
+        return
+      }
+      try { this.socket.close() } catch {}
+      this.socket = null
     }
-    // Last resort: static placeholder (should not happen in modern browsers)
-    return '00000000-0000-4000-8000-000000000000'
+
+    // The socket is opened with the session so the server knows who we are
+    this.connecting = true
+    getSession()
+      .then((session) => this.open(userName, session))
+      .catch(() => this.handleReconnect(userName))
+      .finally(() => {
+        this.connecting = false
+      })
   }
 
-  connect(userName: string = 'Anonymous') {
+  private open(userName: string, session: Session) {
     // Build WS URL from env when provided, else derive from API base/host
     let wsUrl = ''
     const configured = process.env.NEXT_PUBLIC_WS_URL
@@ -71,15 +68,9 @@ class WebSocketService {
         wsUrl = `${wsProtocol}//${window.location.hostname}:8080/api/ws?name=${encodeURIComponent(userName)}`
       }
     }
-    
-    // Prevent duplicate connections
-    if (this.socket) {
-      if (this.socket.readyState === WebSocket.OPEN || this.socket.readyState === WebSocket.CONNECTING) {
-        return this.socket
-      }
-      try { this.socket.close() } catch {}
-      this.socket = null
-    }
+    // Live updates are scoped to the document the socket joins
+    const { documentId } = useStore.getState()
+    wsUrl += `&document_id=${encodeURIComponent(documentId)}&session=${encodeURIComponent(session.token)}`
 
     this.socket = new WebSocket(wsUrl)
 
@@ -87,21 +78,9 @@ class WebSocketService {
       this.reconnectAttempts = 0
       
       const store = useStore.getState()
-      // Ensure a stable UUID for this client so we can
-      // reliably identify "own" changes from broadcasts
-      let existingId = undefined as string | undefined
-      try {
-        existingId = localStorage.getItem('storychain-user-id') || undefined
-      } catch {}
-      const userId = existingId && existingId.length === 36
-        ? existingId
-        : this.generateUuid()
-      try {
-        localStorage.setItem('storychain-user-id', userId)
-      } catch {}
-
+      // The session's user ID is how we recognise "own" changes from broadcasts
       store.setCurrentUser({
-        id: userId,
+        id: session.user_id,
         name: userName,
         status: 'online'
       })
@@ -119,8 +98,6 @@ class WebSocketService {
         this.handleMessage(message)
       } catch {}
     }
-
-    return this.socket
   }
 
   private handleMessage(raw: unknown) {
@@ -226,27 +203,6 @@ class WebSocketService {
     }
   }
 
-  sendTextChange(change: {
-    documentId: string
-    changeType: string
-    content: string
-    position: number
-    length: number
-  }) {
-    if (this.socket?.readyState === WebSocket.OPEN) {
-      const store = useStore.getState()
-      const message = {
-        type: 'text_change',
-        data: {
-          ...change,
-          userID: store.currentUser?.id,
-          userName: store.currentUser?.name
-        }
-      }
-      this.socket.send(JSON.stringify(message))
-    }
-  }
-
   updateUserName(newName: string) {
     if (this.socket?.readyState === WebSocket.OPEN) {
       const message = {